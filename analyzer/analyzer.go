@@ -0,0 +1,615 @@
+// Package analyzer provides Analyzer, which reports unused symbols the same
+// way punused does, for use with go vet -vettool, golangci-lint and other
+// go/analysis drivers.
+package analyzer
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/types/objectpath"
+
+	"github.com/rprtr258/punused/engine"
+	"github.com/rprtr258/punused/internal/exclude"
+	"github.com/rprtr258/punused/internal/removal"
+)
+
+const _doc = `report unused symbols
+
+The punused analyzer reports symbols which are never used (EU1002) or are
+used only in _test.go files (EU1001), suggesting to remove unused ones.
+Functions called by Go toolchain, like init and tests, and methods
+implementing well-known interfaces are never reported. Methods implementing
+interfaces declared in other packages are assumed to be used. Config of
+punused and //punused:ignore and //nolint directives are applied the same way
+as by punused.
+
+Unexported symbols and symbols of main and external test packages are
+reported when their own package is analyzed. Exported symbols can be used by
+any importer, so they are reported when main package of the same module is
+analyzed, if neither it nor any of its dependencies use them. In modules with
+several commands this reports symbols used by other commands only, disable
+it with -exported=false then.`
+
+// Analyzer reports unused symbols, see package documentation.
+var Analyzer = &analysis.Analyzer{
+	Name:      "punused",
+	Doc:       _doc,
+	URL:       "https://github.com/rprtr258/punused",
+	Run:       run,
+	FactTypes: []analysis.Fact{new(Exported)},
+}
+
+// reportExported enables reporting exported symbols of imported packages.
+var reportExported bool
+
+func init() {
+	Analyzer.Flags.BoolVar(&reportExported, "exported", true,
+		"report exported symbols of the same module unused by analyzed main package and its dependencies")
+	Analyzer.Flags.StringVar(&configFile, "config", "",
+		"punused config file, searched for in module directory and its parents by default")
+}
+
+func keyOf(obj types.Object) (objectKey, bool) {
+	path, err := objectpath.For(obj)
+	if err != nil {
+		return "", false
+	}
+	return objectKey(obj.Pkg().Path() + " " + string(path)), true
+}
+
+// symbol is a declaration analyzer checks.
+type symbol struct {
+	Obj types.Object
+	// Kind and Name are kind and name of symbol the way gopls names them, as punused reports them.
+	Kind, Name string
+	Ident      *ast.Ident
+	File       *ast.File
+	// Parent is index of enclosing type for fields and interface methods, -1 for top level symbols.
+	Parent int
+	// Test is set if symbol is declared in _test.go file.
+	Test bool
+	// Desc describes symbol for filter.
+	Desc engine.Symbol
+}
+
+// symbols returns checked symbols declared in package, except excluded ones.
+// Symbols of kinds not checked are skipped together with their fields and methods.
+func symbols(pass *analysis.Pass, flt filter) ([]symbol, error) {
+	var res []symbol
+	for _, f := range pass.Files {
+		filename := pass.Fset.File(f.Pos()).Name()
+		interfaces, err := flt.interfaces(filename)
+		if err != nil {
+			return nil, err
+		}
+
+		// add adds symbol if it is checked, returning whether it is added
+		add := func(ident *ast.Ident, kind, name string, parent int) bool {
+			obj := pass.TypesInfo.Defs[ident]
+			if obj == nil || ident.Name == "_" || err != nil {
+				return false
+			}
+
+			desc := engine.Symbol{Filename: filename, Line: pass.Fset.Position(ident.Pos()).Line - 1, Kind: kind, Name: name, Parent: ""}
+			if parent != -1 {
+				desc.Parent = res[parent].Name
+			}
+			var checked bool
+			if checked, err = flt.checks(desc); !checked {
+				return false
+			}
+			res = append(res, symbol{obj, kind, name, ident, f, parent, strings.HasSuffix(filename, "_test.go"), desc})
+			return true
+		}
+
+		for _, decl := range f.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				switch {
+				case decl.Recv == nil:
+					sig, _ := pass.TypesInfo.Defs[decl.Name].Type().(*types.Signature)
+					if !exclude.IsEntrypoint(filename, f, decl, sig) {
+						add(decl.Name, "function", decl.Name.Name, -1)
+					}
+				case len(decl.Recv.List) == 1 && !exclude.IsWellKnownMethod(decl.Name.Name, types.ExprString(decl.Type), interfaces):
+					add(decl.Name, "method", fmt.Sprintf("(%s).%s", types.ExprString(decl.Recv.List[0].Type), decl.Name.Name), -1)
+				}
+			case *ast.GenDecl:
+				for _, spec := range decl.Specs {
+					switch spec := spec.(type) {
+					case *ast.TypeSpec:
+						parent := len(res)
+						switch typ := spec.Type.(type) {
+						case *ast.StructType:
+							if !add(spec.Name, "struct", spec.Name.Name, -1) {
+								continue
+							}
+							for _, field := range typ.Fields.List {
+								for _, name := range field.Names {
+									add(name, "field", name.Name, parent)
+								}
+							}
+						case *ast.InterfaceType:
+							if !add(spec.Name, "interface", spec.Name.Name, -1) {
+								continue
+							}
+							for _, method := range typ.Methods.List {
+								for _, name := range method.Names {
+									add(name, "method", name.Name, parent)
+								}
+							}
+						case *ast.FuncType:
+							add(spec.Name, "function", spec.Name.Name, -1)
+						default:
+							add(spec.Name, "class", spec.Name.Name, -1)
+						}
+					case *ast.ValueSpec:
+						kind := "variable"
+						if decl.Tok == token.CONST {
+							kind = "constant"
+						}
+						for _, name := range spec.Names {
+							add(name, kind, name.Name, -1)
+						}
+					}
+				}
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+// origin returns generic object instantiated object originates from.
+func origin(obj types.Object) types.Object {
+	switch obj := obj.(type) {
+	case *types.Func:
+		return obj.Origin()
+	case *types.Var:
+		return obj.Origin()
+	default:
+		return obj
+	}
+}
+
+// usage maps objects used in package to whether they are used outside of _test.go files.
+type usage map[types.Object]bool
+
+func newUsage(pass *analysis.Pass) usage {
+	u := usage{}
+	for _, f := range pass.Files {
+		test := strings.HasSuffix(pass.Fset.File(f.Pos()).Name(), "_test.go")
+		ast.Inspect(f, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				if obj := pass.TypesInfo.Uses[id]; obj != nil {
+					obj = origin(obj)
+					u[obj] = u[obj] || !test
+				}
+			}
+			return true
+		})
+	}
+	return u
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) { return f(path) }
+
+// testUses returns objects of package used in its _test.go files which are not analyzed,
+// as when package is imported by other package or test files are excluded by build
+// constraints. Tags and platform driver analyzes package for are not known, so test files
+// are not filtered by build constraints and uses in any of them count. Test files are type
+// checked against package, so that objects they use are found the same way as in package
+// itself. Imports of test files, which package does not import, are not resolved, which is
+// tolerated since only uses of objects of package are needed.
+func testUses(pass *analysis.Pass) (map[types.Object]bool, error) {
+	if len(pass.Files) == 0 {
+		return map[types.Object]bool{}, nil
+	}
+
+	analyzed := map[string]bool{}
+	for _, f := range pass.Files {
+		analyzed[pass.Fset.File(f.Pos()).Name()] = true
+	}
+	dir := filepath.Dir(pass.Fset.File(pass.Files[0].Pos()).Name())
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var internal, external []*ast.File
+	for _, entry := range entries {
+		filename := filepath.Join(dir, entry.Name())
+		if !strings.HasSuffix(filename, "_test.go") || entry.IsDir() || analyzed[filename] {
+			continue
+		}
+
+		f, err := parser.ParseFile(pass.Fset, filename, nil, parser.SkipObjectResolution)
+		if err != nil {
+			continue
+		}
+		switch f.Name.Name {
+		case pass.Pkg.Name():
+			internal = append(internal, f)
+		case pass.Pkg.Name() + "_test":
+			external = append(external, f)
+		}
+	}
+	if len(internal) == 0 && len(external) == 0 {
+		return map[types.Object]bool{}, nil
+	}
+
+	pkgs := map[string]*types.Package{pass.Pkg.Path(): pass.Pkg}
+	for _, pkg := range imports(pass.Pkg) {
+		pkgs[pkg.Path()] = pkg
+	}
+	check := func(path string, files []*ast.File) (*types.Package, *types.Info) {
+		info := &types.Info{Uses: map[*ast.Ident]types.Object{}}
+		conf := types.Config{
+			Importer: importerFunc(func(path string) (*types.Package, error) {
+				if pkg, ok := pkgs[path]; ok {
+					return pkg, nil
+				}
+				return nil, fmt.Errorf("package %s is not imported by %s", path, pass.Pkg.Path())
+			}),
+			Error: func(error) {},
+		}
+		pkg, _ := conf.Check(path, pass.Fset, files, info)
+		return pkg, info
+	}
+
+	// objects of package type checked again with test files are the same objects as in pass
+	defs := map[token.Pos]types.Object{}
+	for _, obj := range pass.TypesInfo.Defs {
+		if obj != nil {
+			defs[obj.Pos()] = obj
+		}
+	}
+	res := map[types.Object]bool{}
+	collect := func(pkg *types.Package, info *types.Info, files []*ast.File) {
+		for _, f := range files {
+			ast.Inspect(f, func(n ast.Node) bool {
+				if id, ok := n.(*ast.Ident); ok {
+					if obj := info.Uses[id]; obj != nil && obj.Pkg() != nil && (obj.Pkg() == pkg || obj.Pkg() == pass.Pkg) {
+						if obj, ok := defs[origin(obj).Pos()]; ok {
+							res[obj] = true
+						}
+					}
+				}
+				return true
+			})
+		}
+	}
+
+	if len(internal) > 0 {
+		pkg, info := check(pass.Pkg.Path(), slices.Concat(pass.Files, internal))
+		collect(pkg, info, internal)
+		pkgs[pass.Pkg.Path()] = pkg
+	}
+	if len(external) > 0 {
+		_, info := check(pass.Pkg.Path()+"_test", external)
+		collect(pkgs[pass.Pkg.Path()], info, external)
+	}
+	return res, nil
+}
+
+// receiver returns named type method is declared on, nil for functions and methods of generic types.
+func receiver(fn *types.Func) *types.Named {
+	recv := fn.Signature().Recv()
+	if recv == nil {
+		return nil
+	}
+
+	t := recv.Type()
+	if ptr, ok := t.(*types.Pointer); ok {
+		t = ptr.Elem()
+	}
+	named, ok := t.(*types.Named)
+	if !ok || named.TypeParams().Len() > 0 {
+		return nil
+	}
+	return named
+}
+
+// implements checks whether concrete type, or pointer to it, implements interface.
+func implements(t *types.Named, iface *types.Named) bool {
+	i, ok := iface.Underlying().(*types.Interface)
+	if !ok || types.IsInterface(t) {
+		return false
+	}
+	return types.Implements(t, i) || types.Implements(types.NewPointer(t), i)
+}
+
+// related checks whether methods with the same name are related, that is one of them
+// is a method of interface, which receiver of another implements.
+func related(a, b *types.Func) bool {
+	ra, rb := receiver(a), receiver(b)
+	return ra != nil && rb != nil && (implements(ra, rb) || implements(rb, ra))
+}
+
+// status returns whether object is used, directly or through related methods: concrete
+// method is used if method of interface it implements is used and interface method is
+// used if any implementation of it is used. The same way gopls finds references.
+func (u usage) status(obj types.Object) (used, outsideTests bool) {
+	outsideTests, used = u[obj]
+	fn, ok := obj.(*types.Func)
+	if !ok || receiver(fn) == nil || outsideTests {
+		return used, outsideTests
+	}
+
+	for other, otherOutsideTests := range u {
+		if other, ok := other.(*types.Func); ok && other.Name() == fn.Name() && other != fn && related(fn, other) {
+			used, outsideTests = true, outsideTests || otherOutsideTests
+		}
+	}
+	return used, outsideTests
+}
+
+// imports returns packages package imports, directly or indirectly.
+func imports(pkg *types.Package) []*types.Package {
+	var res []*types.Package
+	seen := map[*types.Package]bool{}
+	var visit func(p *types.Package)
+	visit = func(p *types.Package) {
+		for _, imp := range p.Imports() {
+			if !seen[imp] {
+				seen[imp] = true
+				res = append(res, imp)
+				visit(imp)
+			}
+		}
+	}
+	visit(pkg)
+	return res
+}
+
+// interfaces returns named interfaces declared in packages, including error.
+func interfaces(pkgs []*types.Package) []*types.Named {
+	res := []*types.Named{types.Universe.Lookup("error").Type().(*types.Named)}
+	for _, pkg := range pkgs {
+		scope := pkg.Scope()
+		for _, name := range scope.Names() {
+			if obj, ok := scope.Lookup(name).(*types.TypeName); ok && !obj.IsAlias() && types.IsInterface(obj.Type()) {
+				if named, ok := obj.Type().(*types.Named); ok {
+					res = append(res, named)
+				}
+			}
+		}
+	}
+	return res
+}
+
+// isImportable checks whether package can be imported, that is it is neither main package nor external test package.
+func isImportable(pkg *types.Package) bool {
+	return pkg.Name() != "main" && !strings.HasSuffix(pkg.Path(), "_test")
+}
+
+func run(pass *analysis.Pass) (any, error) {
+	u := newUsage(pass)
+	deps := imports(pass.Pkg)
+
+	// exported symbols are checked within module only
+	agg := newAggregate()
+	if pass.Module != nil {
+		for _, pf := range pass.AllPackageFacts() {
+			if fact, ok := pf.Fact.(*Exported); ok && pf.Package != pass.Pkg {
+				agg.add(fact, pass.Module.Path)
+			}
+		}
+		markUsed(agg, u, deps)
+	}
+
+	var flt filter
+	if len(pass.Files) > 0 {
+		var err error
+		if flt, err = newFilter(pass.Fset.File(pass.Files[0].Pos()).Name()); err != nil {
+			return nil, err
+		}
+	}
+	symbols, err := symbols(pass, flt)
+	if err != nil {
+		return nil, err
+	}
+
+	var ifaces []*types.Named
+	var tests map[types.Object]bool
+	codes := make([]engine.Code, len(symbols))
+	for i, s := range symbols {
+		used, outsideTests := u.status(s.Obj)
+		if fn, ok := s.Obj.(*types.Func); ok && !used && receiver(fn) != nil && !types.IsInterface(receiver(fn)) {
+			// method can be called through interface of dependency
+			if ifaces == nil {
+				ifaces = interfaces(deps)
+			}
+			used = slices.ContainsFunc(ifaces, func(iface *types.Named) bool {
+				m, _, _ := types.LookupFieldOrMethod(iface, false, fn.Pkg(), fn.Name())
+				return m != nil && implements(receiver(fn), iface)
+			})
+			outsideTests = used
+		}
+
+		if !used {
+			// package is analyzed without its tests when imported, but
+			// symbols used in them are reported as used in test only anyway
+			if tests == nil {
+				if tests, err = testUses(pass); err != nil {
+					return nil, err
+				}
+			}
+			used = tests[s.Obj]
+		}
+
+		switch {
+		case !used:
+			codes[i] = engine.CodeUnused
+		case !outsideTests && !s.Test:
+			codes[i] = engine.CodeTestOnly
+		}
+	}
+
+	// fields and methods of reported type are not reported separately
+	reported := make([]bool, len(symbols))
+	for i, s := range symbols {
+		if codes[i] == "" || s.Parent != -1 && reported[s.Parent] {
+			continue
+		}
+
+		tf := pass.Fset.File(s.File.Pos())
+		src, err := pass.ReadFile(tf.Name())
+		if err != nil {
+			return nil, err
+		}
+		// fields and methods of removed type are not removed separately
+		var sp removal.Span
+		if codes[i] == engine.CodeUnused && (s.Parent == -1 || codes[s.Parent] != engine.CodeUnused) {
+			if at, ok := removal.At(pass.Fset, s.File, s.Ident.Pos()); ok {
+				sp = removal.ExpandToLines(src, at)
+			}
+		}
+
+		if !s.Obj.Exported() || !isImportable(pass.Pkg) {
+			if reported[i], err = flt.reports(s.Desc, codes[i]); err != nil {
+				return nil, err
+			} else if reported[i] {
+				report(pass, codes[i], s.Kind, s.Name, s.Ident.Pos(), s.Ident.End(), tf, sp)
+			}
+			continue
+		}
+		// exported symbols of _test.go files can be used by external test package only, which is not checked
+		if s.Test || pass.Module == nil {
+			continue
+		}
+		key, ok := keyOf(s.Obj)
+		if !ok {
+			continue
+		}
+		// code is known once importers are analyzed, so suppressions of both codes are recorded
+		var suppressed []engine.Code
+		for _, c := range []engine.Code{engine.CodeTestOnly, engine.CodeUnused} {
+			if ok, err := flt.reports(s.Desc, c); err != nil {
+				return nil, err
+			} else if !ok {
+				suppressed = append(suppressed, c)
+			}
+		}
+		var parent objectKey
+		if s.Parent != -1 {
+			parent, _ = keyOf(symbols[s.Parent].Obj)
+		}
+
+		agg.candidates[key] = Candidate{
+			Key:        key,
+			Parent:     parent,
+			Kind:       s.Kind,
+			Name:       s.Name,
+			Suppressed: suppressed,
+			TestOnly:   codes[i] == engine.CodeTestOnly,
+			Filename:   tf.Name(),
+			Pos:        tf.Offset(s.Ident.Pos()),
+			Start:      sp.Start,
+			End:        sp.End,
+		}
+		agg.files[tf.Name()] = newFile(tf)
+	}
+	if fact := agg.fact(); fact != nil {
+		pass.ExportPackageFact(fact)
+	}
+
+	if reportExported && pass.Pkg.Name() == "main" && pass.Module != nil {
+		reportUnused(pass, agg)
+	}
+	return nil, nil
+}
+
+// markUsed marks candidates of imported packages, which are used in package.
+func markUsed(agg aggregate, u usage, deps []*types.Package) {
+	pkgs := map[string]*types.Package{}
+	for _, pkg := range deps {
+		pkgs[pkg.Path()] = pkg
+	}
+
+	for key := range agg.candidates {
+		pkgPath, path, _ := strings.Cut(string(key), " ")
+		pkg, ok := pkgs[pkgPath]
+		if !ok {
+			continue
+		}
+		obj, err := objectpath.Object(pkg, objectpath.Path(path))
+		if err != nil {
+			continue
+		}
+
+		switch used, outsideTests := u.status(obj); {
+		case outsideTests:
+			agg.used[key] = true
+		case used:
+			agg.testOnly[key] = true
+		}
+	}
+}
+
+// reportUnused reports candidates of imported packages, which are not used in main package and its dependencies.
+func reportUnused(pass *analysis.Pass, agg aggregate) {
+	// files of imported packages are added to file set, since positions
+	// of objects imported from export data have no columns
+	files := map[string]*token.File{}
+	unused := agg.unused()
+	reported := map[objectKey]bool{}
+	for _, c := range unused {
+		reported[c.Key] = !slices.Contains(c.Suppressed, c.code())
+	}
+	for _, c := range unused {
+		// fields and methods of reported type are not reported separately
+		if c.Key.pkgPath() == pass.Pkg.Path() || !reported[c.Key] || reported[c.Parent] {
+			continue
+		}
+
+		tf, ok := files[c.Filename]
+		if !ok {
+			src, ok := agg.files[c.Filename]
+			if !ok {
+				continue
+			}
+			tf = pass.Fset.AddFile(src.Name, -1, src.Size)
+			if !tf.SetLines(src.Lines) {
+				continue
+			}
+			files[c.Filename] = tf
+		}
+
+		_, name, isMethod := strings.Cut(c.Name, ").")
+		if !isMethod {
+			name = c.Name
+		}
+		pos := tf.Pos(c.Pos)
+		report(pass, c.code(), c.Kind, c.Name, pos, pos+token.Pos(len(name)), tf, removal.Span{Start: c.Start, End: c.End})
+	}
+}
+
+// report reports diagnostic about symbol, suggesting to remove span of file, if it is not empty.
+func report(pass *analysis.Pass, c engine.Code, kind, name string, pos, end token.Pos, tf *token.File, sp removal.Span) {
+	diag := analysis.Diagnostic{
+		Pos:      pos,
+		End:      end,
+		Category: string(c),
+		Message:  fmt.Sprintf("%s %s is %s", kind, name, c.Message()),
+	}
+	if sp.End > sp.Start {
+		diag.SuggestedFixes = []analysis.SuggestedFix{{
+			Message:   fmt.Sprintf("Remove unused %s %s", kind, name),
+			TextEdits: []analysis.TextEdit{{Pos: tf.Pos(sp.Start), End: tf.Pos(sp.End)}},
+		}}
+	}
+	pass.Report(diag)
+}
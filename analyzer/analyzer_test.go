@@ -0,0 +1,98 @@
+package analyzer
+
+import (
+	"cmp"
+	"fmt"
+	"go/token"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	gocmp "github.com/google/go-cmp/cmp"
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/analysistest"
+	"golang.org/x/tools/go/analysis/checker"
+	"golang.org/x/tools/go/packages"
+)
+
+// TestAnalyzer checks diagnostics reported when module is analyzed the way go vet does,
+// including exported symbols reported when main package is analyzed. Config and
+// suppression directives of testdata are applied the same way as by punused.
+func TestAnalyzer(t *testing.T) {
+	const golden = `
+app/main.go:9:5 variable unusedVar is unused (EU1002), removes lines 9-9
+lib/lib.go:9:6 function Unused is unused (EU1002), removes lines 8-9
+lib/lib.go:12:6 function UsedInTest is used in test only (EU1001)
+lib/lib.go:32:2 method Perimeter is unused (EU1002)
+lib/lib.go:37:2 field Color is unused (EU1002), removes lines 37-37
+lib/lib.go:52:6 struct point is unused (EU1002), removes lines 52-54
+lib/lib.go:57:6 function UsedInIntegrationTest is used in test only (EU1001)
+`
+	dir, err := filepath.Abs("testdata")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkgs, err := packages.Load(&packages.Config{
+		Mode: packages.LoadAllSyntax | packages.NeedModule,
+		Dir:  dir,
+		// go vet analyzes package with its tests instead of package itself
+		Tests: true,
+	}, "./app", "./lib")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkgs = slices.DeleteFunc(pkgs, func(pkg *packages.Package) bool {
+		return strings.HasSuffix(pkg.ID, ".test") || slices.ContainsFunc(pkgs, func(other *packages.Package) bool {
+			return other.ID == fmt.Sprintf("%s [%s.test]", pkg.ID, pkg.ID)
+		})
+	})
+
+	graph, err := checker.Analyze([]*analysis.Analyzer{Analyzer}, pkgs, &checker.Options{SanityCheck: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	type line struct {
+		pos  token.Position
+		text string
+	}
+	var lines []line
+	for _, act := range graph.Roots {
+		if act.Err != nil {
+			t.Fatal(act.Err)
+		}
+
+		for _, diag := range act.Diagnostics {
+			pos := act.Package.Fset.Position(diag.Pos)
+			filename, err := filepath.Rel(dir, pos.Filename)
+			if err != nil {
+				t.Fatal(err)
+			}
+			text := fmt.Sprintf("%s:%d:%d %s (%s)", filepath.ToSlash(filename), pos.Line, pos.Column, diag.Message, diag.Category)
+			for _, fix := range diag.SuggestedFixes {
+				for _, edit := range fix.TextEdits {
+					text += fmt.Sprintf(", removes lines %d-%d",
+						act.Package.Fset.Position(edit.Pos).Line, act.Package.Fset.Position(edit.End-1).Line)
+				}
+			}
+			lines = append(lines, line{pos, text})
+		}
+	}
+	slices.SortFunc(lines, func(a, b line) int {
+		return cmp.Or(strings.Compare(a.pos.Filename, b.pos.Filename), a.pos.Line-b.pos.Line, a.pos.Column-b.pos.Column)
+	})
+
+	var got strings.Builder
+	for _, l := range lines {
+		got.WriteString(l.text + "\n")
+	}
+	if diff := gocmp.Diff(strings.TrimSpace(golden), strings.TrimSpace(got.String())); diff != "" {
+		t.Fatal("unexpected diagnostics\n+ actual\n- expected\n" + diff)
+	}
+}
+
+// TestAnalyzerSuggestedFixes checks that applying all suggested fixes removes unused symbols,
+// see testdata/fix/main.go.golden.
+func TestAnalyzerSuggestedFixes(t *testing.T) {
+	analysistest.RunWithSuggestedFixes(t, "testdata", Analyzer, "./fix")
+}
@@ -0,0 +1,158 @@
+package analyzer
+
+import (
+	"cmp"
+	"fmt"
+	"go/token"
+	"maps"
+	"slices"
+	"strings"
+
+	"github.com/rprtr258/punused/engine"
+)
+
+// objectKey identifies object across analyzed packages as package path and object path.
+type objectKey string
+
+func (k objectKey) pkgPath() string {
+	path, _, _ := strings.Cut(string(k), " ")
+	return path
+}
+
+// Exported is a fact about package, which describes exported symbols of it
+// and its dependencies from the same module, which are not used in their own
+// package. Whether they are used at all is known only to importers, so they
+// are reported when main package is analyzed. Package facts are available to
+// direct importers only, so each package aggregates facts of its imports.
+type Exported struct {
+	// Candidates are unused exported symbols, sorted by Key.
+	Candidates []Candidate
+	// Used are candidates used in package or its dependencies outside of _test.go files.
+	Used []objectKey
+	// TestOnly are candidates used in _test.go files only.
+	TestOnly []objectKey
+	// Files describe files candidates are declared in, so that diagnostics and fixes
+	// can be reported in them when importer is analyzed.
+	Files []File
+}
+
+func (*Exported) AFact() {}
+
+func (e *Exported) String() string {
+	return fmt.Sprintf("%d candidates, %d used, %d used in tests", len(e.Candidates), len(e.Used), len(e.TestOnly))
+}
+
+// Candidate is exported symbol, which is not used in its own package.
+type Candidate struct {
+	Key objectKey
+	// Parent is key of type declaring field or interface method, empty for top level symbols.
+	Parent objectKey
+	// Kind and Name of symbol, as in diagnostic message.
+	Kind, Name string
+	// Suppressed are codes of diagnostics about symbol suppressed by config or directives.
+	Suppressed []engine.Code
+	// TestOnly is set if symbol is used in _test.go files of its package.
+	TestOnly bool
+	// Filename is file symbol is declared in.
+	Filename string
+	// Pos is byte offset of symbol name.
+	Pos int
+	// Start and End are byte offsets of source to remove with declaration,
+	// End is zero if symbol can't be removed automatically.
+	Start, End int
+}
+
+// code returns code of diagnostic about unused candidate.
+func (c Candidate) code() engine.Code {
+	if c.TestOnly {
+		return engine.CodeTestOnly
+	}
+	return engine.CodeUnused
+}
+
+// File describes lines of source file.
+type File struct {
+	Name string
+	Size int
+	// Lines are byte offsets of line starts.
+	Lines []int
+}
+
+// newFile describes lines of file.
+func newFile(tf *token.File) File {
+	return File{tf.Name(), tf.Size(), tf.Lines()}
+}
+
+// aggregate collects Exported facts of package and its imports.
+type aggregate struct {
+	candidates     map[objectKey]Candidate
+	used, testOnly map[objectKey]bool
+	files          map[string]File
+}
+
+func newAggregate() aggregate {
+	return aggregate{map[objectKey]Candidate{}, map[objectKey]bool{}, map[objectKey]bool{}, map[string]File{}}
+}
+
+// add adds fact about candidates of packages of module.
+func (a aggregate) add(fact *Exported, module string) {
+	inModule := func(key objectKey) bool {
+		path := key.pkgPath()
+		return path == module || strings.HasPrefix(path, module+"/")
+	}
+
+	for _, c := range fact.Candidates {
+		if inModule(c.Key) {
+			a.candidates[c.Key] = c
+		}
+	}
+	for _, key := range fact.Used {
+		a.used[key] = true
+	}
+	for _, key := range fact.TestOnly {
+		a.testOnly[key] = true
+	}
+	for _, f := range fact.Files {
+		a.files[f.Name] = f
+	}
+}
+
+// fact returns aggregated fact, nil if there are no candidates.
+func (a aggregate) fact() *Exported {
+	if len(a.candidates) == 0 {
+		return nil
+	}
+
+	fact := &Exported{}
+	for _, key := range slices.Sorted(maps.Keys(a.candidates)) {
+		fact.Candidates = append(fact.Candidates, a.candidates[key])
+		switch {
+		case a.used[key]:
+			fact.Used = append(fact.Used, key)
+		case a.testOnly[key]:
+			fact.TestOnly = append(fact.TestOnly, key)
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(a.files)) {
+		fact.Files = append(fact.Files, a.files[name])
+	}
+	return fact
+}
+
+// unused returns candidates which are not used outside of tests, sorted by position.
+func (a aggregate) unused() []Candidate {
+	var res []Candidate
+	for key, c := range a.candidates {
+		switch {
+		case a.used[key]:
+			continue
+		case a.testOnly[key]:
+			c.TestOnly = true
+		}
+		res = append(res, c)
+	}
+	slices.SortFunc(res, func(a, b Candidate) int {
+		return cmp.Or(cmp.Compare(a.Filename, b.Filename), cmp.Compare(a.Pos, b.Pos))
+	})
+	return res
+}
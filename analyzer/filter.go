@@ -0,0 +1,76 @@
+package analyzer
+
+import (
+	"path/filepath"
+	"sync"
+
+	"github.com/rprtr258/punused/engine"
+	"github.com/rprtr258/punused/internal/exclude"
+)
+
+// configFile is config file given with -config flag, found the same way as by punused if empty.
+var configFile string
+
+// filters are filters of workspaces by module root directory, shared by packages analyzed concurrently.
+var (
+	filtersMu sync.Mutex
+	filters   = map[string]*engine.Filter{}
+)
+
+// filter applies config and suppression directives of module to symbols, the same way punused does.
+// Zero filter, of file outside of module, checks and reports all symbols.
+type filter struct {
+	f *engine.Filter
+}
+
+// newFilter returns filter of module file belongs to.
+func newFilter(filename string) (filter, error) {
+	root, err := engine.ModuleRoot(filepath.Dir(filename))
+	if err != nil {
+		// file is not in module, e.g. generated into build cache
+		return filter{}, nil
+	}
+
+	filtersMu.Lock()
+	defer filtersMu.Unlock()
+	if f, ok := filters[root]; ok {
+		return filter{f}, nil
+	}
+
+	f, err := engine.NewFilter(engine.Options{WorkspaceDir: root, ConfigFile: configFile})
+	if err != nil {
+		return filter{}, err
+	}
+	filters[root] = f
+	return filter{f}, nil
+}
+
+func (f filter) checks(s engine.Symbol) (bool, error) {
+	if f.f == nil {
+		return true, nil
+	}
+
+	filtersMu.Lock()
+	defer filtersMu.Unlock()
+	return f.f.Checks(s)
+}
+
+func (f filter) interfaces(filename string) ([]exclude.Method, error) {
+	if f.f == nil {
+		return nil, nil
+	}
+
+	filtersMu.Lock()
+	defer filtersMu.Unlock()
+	return f.f.Interfaces(filename)
+}
+
+func (f filter) reports(s engine.Symbol, c engine.Code) (bool, error) {
+	if f.f == nil {
+		return true, nil
+	}
+
+	filtersMu.Lock()
+	defer filtersMu.Unlock()
+	return f.f.Reports(s, c)
+}
@@ -0,0 +1,14 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/rprtr258/punused-analyzer-testdata/lib"
+)
+
+var unusedVar = 1
+
+func main() {
+	var s lib.Shape = lib.Square{Side: 2}
+	fmt.Println(lib.Used(), s.Area())
+}
@@ -0,0 +1,33 @@
+package main
+
+import "fmt"
+
+// unusedFunc is removed with its doc comment.
+func unusedFunc() { // want "function unusedFunc is unused"
+}
+
+type config struct { // want "struct config is unused"
+	name string
+}
+
+type options struct {
+	verbose bool
+	debug   bool // want "field debug is unused"
+}
+
+const (
+	used   = 1
+	unused = 2 // want "constant unused is unused"
+)
+
+type mode int
+
+// constants of iota group are not removed, since it would renumber the rest
+const (
+	modeUnused mode = iota // want "constant modeUnused is unused"
+	modeA
+)
+
+func main() {
+	fmt.Println(options{verbose: true}.verbose, used, modeA)
+}
@@ -0,0 +1,3 @@
+module github.com/rprtr258/punused-analyzer-testdata
+
+go 1.24.0
@@ -0,0 +1,57 @@
+package lib
+
+// Used is used by app.
+func Used() int {
+	return helper()
+}
+
+// Unused is not used anywhere.
+func Unused() {}
+
+// UsedInTest is used in tests of lib only, which app does not see.
+func UsedInTest() {}
+
+func helper() int {
+	return 1
+}
+
+func unusedHelper() {}
+
+const usedInTestConst = 1 //punused:ignore EU1001 fixture of tests
+
+// Ignored is not used, but kept intentionally.
+//
+//punused:ignore
+func Ignored() {}
+
+//nolint:punused
+func nolinted() {}
+
+type Shape interface {
+	Area() float64
+	Perimeter() float64
+}
+
+type Square struct {
+	Side  float64
+	Color string
+}
+
+func (s Square) Area() float64 {
+	return s.Side * s.Side
+}
+
+func (s Square) Perimeter() float64 {
+	return 4 * s.Side
+}
+
+func (s Square) String() string {
+	return "square"
+}
+
+type point struct {
+	x, y int
+}
+
+// UsedInIntegrationTest is used in tests built with integration tag only.
+func UsedInIntegrationTest() {}
@@ -0,0 +1,9 @@
+//go:build integration
+
+package lib
+
+import "testing"
+
+func TestIntegration(t *testing.T) {
+	UsedInIntegrationTest()
+}
@@ -0,0 +1,15 @@
+package lib
+
+import "testing"
+
+func TestUsedInTest(t *testing.T) {
+	UsedInTest()
+	if usedInTestConst != 1 {
+		t.Fail()
+	}
+}
+
+// tb is alias of testing type, BenchmarkAlias is still recognized as benchmark
+type tb = *testing.B
+
+func BenchmarkAlias(b tb) {}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"os"
+	"slices"
+
+	"github.com/pkg/errors"
+
+	"github.com/rprtr258/punused/engine"
+)
+
+const (
+	_defaultBaselineFilename = ".punused-baseline.json"
+	// _baselineVersion must be bumped on any incompatible change of baseline file.
+	_baselineVersion = 1
+)
+
+// baselineEntry identifies diagnostic regardless of its position in file,
+// so that baseline survives unrelated edits.
+type baselineEntry struct {
+	// Package is directory of package relative to workspace.
+	Package string `json:"package"`
+	// Name is qualified name of symbol.
+	Name string      `json:"name"`
+	Kind string      `json:"kind"`
+	Code engine.Code `json:"code"`
+}
+
+func newBaselineEntry(f engine.Finding) baselineEntry {
+	return baselineEntry{
+		Package: packageDir(f),
+		Name:    f.QualifiedName,
+		Kind:    f.Kind,
+		Code:    f.Code,
+	}
+}
+
+// fingerprint is position independent identifier of diagnostic.
+// Code is not part of fingerprint, so symbol which went from unused
+// to used in tests only is not considered new.
+func (e baselineEntry) fingerprint() string {
+	return e.Package + ":" + e.Kind + ":" + e.Name
+}
+
+type baselineFile struct {
+	Version     int             `json:"version"`
+	Diagnostics []baselineEntry `json:"diagnostics"`
+}
+
+// baseline is a multiset of fingerprints of known diagnostics.
+type baseline map[string]int
+
+func readBaseline(filename string) (baseline, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "read baseline")
+	}
+
+	var f baselineFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, errors.Wrapf(err, "parse baseline %s", filename)
+	}
+	if f.Version != _baselineVersion {
+		return nil, errors.Errorf("unsupported baseline version %d, expected %d, regenerate baseline", f.Version, _baselineVersion)
+	}
+
+	res := baseline{}
+	for _, e := range f.Diagnostics {
+		res[e.fingerprint()]++
+	}
+	return res, nil
+}
+
+// consume reports whether diagnostic is known in baseline. Each baseline
+// entry matches single diagnostic, so duplicates above baseline are new.
+func (b baseline) consume(entry baselineEntry) bool {
+	fp := entry.fingerprint()
+	if b[fp] == 0 {
+		return false
+	}
+	b[fp]--
+	return true
+}
+
+// baselineReporter collects all diagnostics and writes them into baseline file.
+type baselineReporter struct {
+	filename string
+	entries  []baselineEntry
+}
+
+func (r *baselineReporter) Report(f engine.Finding) error {
+	r.entries = append(r.entries, newBaselineEntry(f))
+	return nil
+}
+
+func (r *baselineReporter) Flush() error {
+	// sort, so that baseline diffs are minimal
+	slices.SortFunc(r.entries, func(a, b baselineEntry) int {
+		return cmp.Or(
+			cmp.Compare(a.Package, b.Package),
+			cmp.Compare(a.Name, b.Name),
+			cmp.Compare(a.Kind, b.Kind),
+			cmp.Compare(a.Code, b.Code),
+		)
+	})
+
+	b, err := json.MarshalIndent(baselineFile{_baselineVersion, r.entries}, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "marshal baseline")
+	}
+
+	return errors.Wrap(os.WriteFile(r.filename, append(b, '\n'), 0o644), "write baseline")
+}
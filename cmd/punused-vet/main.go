@@ -0,0 +1,14 @@
+// Command punused-vet runs punused analyzer as go vet tool:
+//
+//	go vet -vettool=$(which punused-vet) ./...
+package main
+
+import (
+	"golang.org/x/tools/go/analysis/unitchecker"
+
+	"github.com/rprtr258/punused/analyzer"
+)
+
+func main() {
+	unitchecker.Main(analyzer.Analyzer)
+}
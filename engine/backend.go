@@ -0,0 +1,88 @@
+package engine
+
+import (
+	"context"
+	"go/types"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/rprtr258/punused/internal/lsp"
+)
+
+// Backend finds symbols and references in workspace.
+type Backend string
+
+const (
+	// BackendGopls queries gopls, which must be on PATH.
+	BackendGopls Backend = "gopls"
+	// BackendPackages type checks workspace in process using go/packages.
+	BackendPackages Backend = "packages"
+)
+
+// Backends are all supported backends.
+var Backends = []Backend{BackendGopls, BackendPackages}
+
+// backend answers queries about symbols of workspace, which runner analyzes.
+// Queries about symbols and references must be safe for concurrent use.
+type backend interface {
+	// Open makes file, relative to workspace, available for queries. All files
+	// to check are opened before symbols are queried, so that references from
+	// any of them are found.
+	Open(filename string) error
+	// DocumentSymbol returns symbols declared in file relative to workspace,
+	// in the same form gopls returns them.
+	DocumentSymbol(filename string) ([]documentSymbol, error)
+	// DocumentReferences returns references to symbol declared at loc, excluding the declaration.
+	DocumentReferences(loc lsp.Location) ([]lsp.Location, error)
+	// Implementation returns locations of interface methods implemented by method at loc,
+	// or of methods implementing interface method at loc.
+	Implementation(loc lsp.Location) ([]lsp.Location, error)
+	Close() error
+}
+
+// renamer is implemented by backends able to unexport symbols.
+type renamer interface {
+	// Rename returns edits renaming symbol at loc to newName in whole workspace.
+	Rename(loc lsp.Location, newName string) (lsp.WorkspaceEdit, error)
+	// DidChange notifies backend about new content of document, which is not saved on disk.
+	DidChange(uri lsp.URI, text string) error
+}
+
+// typeResolver is implemented by backends which type check workspace.
+type typeResolver interface {
+	// TypeOf returns type of object declared at loc, nil if it is not known.
+	TypeOf(loc lsp.Location) types.Type
+}
+
+// newBackend creates backend of given kind for workspace, concurrency is
+// max number of concurrent queries, if backend limits them.
+func newBackend(ctx context.Context, kind Backend, workspaceDir string, concurrency int) (backend, error) {
+	switch kind {
+	case BackendGopls:
+		return newClient(ctx, workspaceDir, concurrency)
+	case BackendPackages:
+		return newPackagesBackend(ctx, workspaceDir), nil
+	default:
+		return nil, errors.Errorf("unknown backend %q, must be one of %v", kind, Backends)
+	}
+}
+
+// documentURI returns URI of file, relative paths are relative to workspace.
+func documentURI(workspaceDir, filename string) lsp.URI {
+	filename = filepath.ToSlash(filename)
+	if filepath.IsAbs(filename) {
+		return lsp.URI("file://" + filename)
+	}
+	return lsp.URI("file://" + filepath.Join(workspaceDir, filename))
+}
+
+// relPath returns path of document relative to workspace directory.
+func relPath(workspaceDir string, uri lsp.URI) (string, error) {
+	path, err := filepath.Rel(workspaceDir, strings.TrimPrefix(string(uri), "file://"))
+	if err != nil {
+		return "", errors.Wrapf(err, "get relative path for %s", uri)
+	}
+	return filepath.ToSlash(path), nil
+}
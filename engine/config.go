@@ -0,0 +1,437 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gobwas/glob"
+	"github.com/pkg/errors"
+	yaml "gopkg.in/yaml.v3"
+
+	"github.com/rprtr258/punused/internal/exclude"
+	"github.com/rprtr258/punused/internal/lsp"
+)
+
+const (
+	// _configVersion is the only supported version of config schema.
+	_configVersion  = 1
+	_defaultTimeout = 10 * time.Minute
+)
+
+// ConfigFilenames are names of config files searched for, in order of priority.
+// JSON config has the same schema, since JSON is valid YAML.
+var ConfigFilenames = []string{".punused.yaml", ".punused.yml", ".punused.json"}
+
+// FindConfig searches for config file in dir and its parents, stopping at
+// repository root, i.e. directory containing .git, or at filesystem root.
+// Empty filename is returned if config is not found.
+func FindConfig(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", errors.Wrap(err, "get config search directory")
+	}
+
+	for {
+		for _, name := range ConfigFilenames {
+			filename := filepath.Join(dir, name)
+			if _, err := os.Stat(filename); err == nil {
+				return filename, nil
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return "", errors.Wrap(err, "check config file")
+			}
+		}
+
+		if _, err := os.Stat(filepath.Join(dir, ".git")); err == nil {
+			return "", nil
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+type fileConfig struct {
+	ExcludedPaths   []pathExclusion
+	ExcludedSymbols []excludedSymbol
+	Timeout         time.Duration
+	// Kinds of symbols to check, all kinds are checked if empty.
+	Kinds []string
+	// Severities override severities of diagnostic codes.
+	Severities map[Code]Severity
+	// Interfaces are methods of user declared interfaces, implementations of which are not reported.
+	Interfaces []exclude.Method
+	// Roots are qualified names of symbols used implicitly, e.g. by reflection.
+	Roots []string
+	// ExportedRoots overrides whether exported API of non-internal packages is used implicitly.
+	ExportedRoots *bool
+	// NoInherit makes nested config not extend configs of parent directories.
+	NoInherit bool
+}
+
+func defaultConfig() fileConfig {
+	return fileConfig{
+		ExcludedPaths:   nil,
+		ExcludedSymbols: nil,
+		Timeout:         _defaultTimeout,
+		Kinds:           nil,
+		Severities:      nil,
+		Interfaces:      nil,
+		Roots:           nil,
+		ExportedRoots:   nil,
+		NoInherit:       false,
+	}
+}
+
+// Severity of diagnostic, only diagnostics with error severity fail the run.
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+	// SeverityOff disables diagnostic.
+	SeverityOff Severity = "off"
+)
+
+var _severities = []Severity{SeverityError, SeverityWarning, SeverityOff}
+
+// _symbolKinds are kinds of symbols which can be listed in kinds config key.
+var _symbolKinds = []string{"function", "method", "variable", "constant", "field", "struct", "interface", "class"}
+
+// configSchema is the YAML representation of config.
+type configSchema struct {
+	// Version of schema, current version is assumed if omitted.
+	Version int           `yaml:"version"`
+	Timeout *yamlDuration `yaml:"timeout"`
+	// Inherit is false if nested config should not extend parent configs.
+	Inherit *bool `yaml:"inherit"`
+	// Kinds and Severity are kept as nodes to point to line of invalid value.
+	Kinds    []yaml.Node `yaml:"kinds"`
+	Severity yaml.Node   `yaml:"severity"`
+	// Interfaces are declared by user, methods are kept as nodes to point to line of invalid method.
+	Interfaces []struct {
+		Name    string      `yaml:"name"`
+		Methods []yaml.Node `yaml:"methods"`
+	} `yaml:"interfaces"`
+	Reachability struct {
+		// Exported is false if exported API of non-internal packages is not used implicitly,
+		// e.g. in applications, which are not imported by other modules.
+		Exported *bool    `yaml:"exported"`
+		Roots    []string `yaml:"roots"`
+	} `yaml:"reachability"`
+	Exclude struct {
+		// Paths and Symbols are kept as nodes to point to line of invalid glob or stale symbol.
+		Paths   []yaml.Node `yaml:"paths"`
+		Symbols []yaml.Node `yaml:"symbols"`
+	} `yaml:"exclude"`
+}
+
+// yamlDuration is a duration in time.ParseDuration format, like 5m or 1h30m.
+type yamlDuration time.Duration
+
+func (d *yamlDuration) UnmarshalYAML(node *yaml.Node) error {
+	var s string
+	if err := node.Decode(&s); err != nil {
+		return err
+	}
+
+	dur, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("line %d: invalid duration %q, expected value like 5m or 1h30m", node.Line, s)
+	}
+	if dur <= 0 {
+		return fmt.Errorf("line %d: duration must be positive, got %q", node.Line, s)
+	}
+	*d = yamlDuration(dur)
+	return nil
+}
+
+// parseConfig decodes config, rejecting unknown keys and invalid values.
+// Globs in exclude.paths are relative to dir, which is directory of nested config
+// relative to workspace, or empty for root config.
+func parseConfig(data []byte, dir string) (fileConfig, error) {
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+
+	var schema configSchema
+	if err := dec.Decode(&schema); err != nil && err != io.EOF {
+		return fileConfig{}, err
+	}
+
+	if schema.Version != 0 && schema.Version != _configVersion {
+		return fileConfig{}, errors.Errorf("unsupported config version %d, expected %d", schema.Version, _configVersion)
+	}
+
+	c := defaultConfig()
+	if schema.Timeout != nil {
+		if dir != "" {
+			return fileConfig{}, errors.New("timeout can be set only in root config")
+		}
+		c.Timeout = time.Duration(*schema.Timeout)
+	}
+	c.NoInherit = schema.Inherit != nil && !*schema.Inherit
+	c.Roots = schema.Reachability.Roots
+	c.ExportedRoots = schema.Reachability.Exported
+
+	for _, node := range schema.Exclude.Paths {
+		if node.Kind != yaml.ScalarNode {
+			return fileConfig{}, errors.Errorf("line %d: exclude.paths entry must be a string", node.Line)
+		}
+
+		pattern := node.Value
+		if dir != "" {
+			pattern = glob.QuoteMeta(dir) + "/" + pattern
+		}
+		g, err := glob.Compile(pattern)
+		if err != nil {
+			return fileConfig{}, errors.Wrapf(err, "line %d: invalid glob %q in exclude.paths", node.Line, node.Value)
+		}
+		c.ExcludedPaths = append(c.ExcludedPaths, pathExclusion{g, pattern})
+	}
+
+	for _, node := range schema.Exclude.Symbols {
+		if node.Kind != yaml.ScalarNode {
+			return fileConfig{}, errors.Errorf("line %d: exclude.symbols entry must be a string", node.Line)
+		}
+		c.ExcludedSymbols = append(c.ExcludedSymbols, excludedSymbol{
+			Name: node.Value,
+			Pos:  lsp.Position{Line: node.Line - 1, Character: node.Column - 1},
+		})
+	}
+
+	for _, node := range schema.Kinds {
+		if node.Kind != yaml.ScalarNode || !slices.Contains(_symbolKinds, node.Value) {
+			return fileConfig{}, errors.Errorf("line %d: unknown symbol kind %q, expected one of %v", node.Line, node.Value, _symbolKinds)
+		}
+		c.Kinds = append(c.Kinds, node.Value)
+	}
+
+	for _, iface := range schema.Interfaces {
+		for _, node := range iface.Methods {
+			m, err := exclude.ParseMethod(iface.Name, node.Value)
+			if node.Kind != yaml.ScalarNode || err != nil {
+				return fileConfig{}, errors.Errorf("line %d: invalid method %q of interface %q, expected method like Name(params) results", node.Line, node.Value, iface.Name)
+			}
+			c.Interfaces = append(c.Interfaces, m)
+		}
+	}
+
+	switch schema.Severity.Kind {
+	case 0:
+	case yaml.MappingNode:
+		c.Severities = map[Code]Severity{}
+		for i := 0; i+1 < len(schema.Severity.Content); i += 2 {
+			key, value := schema.Severity.Content[i], schema.Severity.Content[i+1]
+			if !slices.Contains(Codes, Code(key.Value)) {
+				return fileConfig{}, errors.Errorf("line %d: unknown diagnostic code %q, expected one of %v", key.Line, key.Value, Codes)
+			}
+			if !slices.Contains(_severities, Severity(value.Value)) {
+				return fileConfig{}, errors.Errorf("line %d: unknown severity %q, expected one of %v", value.Line, value.Value, _severities)
+			}
+			c.Severities[Code(key.Value)] = Severity(value.Value)
+		}
+	default:
+		return fileConfig{}, errors.Errorf("line %d: severity must be a mapping from diagnostic code to severity", schema.Severity.Line)
+	}
+
+	return c, nil
+}
+
+func readYAMLConfig(filename, dir string) (fileConfig, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return fileConfig{}, err
+	}
+
+	c, err := parseConfig(data, dir)
+	if err != nil {
+		return fileConfig{}, fmt.Errorf("%s: %w", filename, err)
+	}
+	return c, nil
+}
+
+// pathExclusion is exclude.paths config entry.
+type pathExclusion struct {
+	glob.Glob
+	// Pattern is glob as written in config, prefixed with directory of nested config.
+	Pattern string
+}
+
+// excludedSymbol is exclude.symbols config entry.
+type excludedSymbol struct {
+	Name string
+	// Pos is position of entry in config file.
+	Pos lsp.Position
+}
+
+// symbolExclusion is exclude.symbols entry together with config it is declared in.
+type symbolExclusion struct {
+	excludedSymbol
+	// ConfigFile is absolute path of config file.
+	ConfigFile string
+	// Dir is directory of config relative to workspace, "." for root config.
+	Dir string
+}
+
+// matches checks whether exclusion applies to symbol, either directly or through its parent.
+func (e symbolExclusion) matches(s symbol) bool {
+	return e.Name == s.QualifiedName() || e.Name == s.Parent
+}
+
+// dirConfig is effective config for files in directory, combining
+// root config with nested configs of directory and its parents.
+type dirConfig struct {
+	ExcludedPaths   []pathExclusion
+	ExcludedSymbols []symbolExclusion
+	// Kinds of symbols to check, all kinds are checked if empty.
+	Kinds      []string
+	Severities map[Code]Severity
+	// Interfaces are methods of user declared interfaces.
+	Interfaces []exclude.Method
+	// Roots are qualified names of symbols used implicitly.
+	Roots []string
+	// ExportedRoots is set if exported API of non-internal packages is used implicitly.
+	ExportedRoots bool
+}
+
+// defaultDirConfig returns config used when no config file is given.
+// Stale suppressions are not failing the run unless failOnStale is set.
+func defaultDirConfig(failOnStale bool) *dirConfig {
+	staleSeverity := SeverityWarning
+	if failOnStale {
+		staleSeverity = SeverityError
+	}
+	return &dirConfig{
+		ExcludedPaths:   nil,
+		ExcludedSymbols: nil,
+		Kinds:           nil,
+		Interfaces:      nil,
+		Roots:           nil,
+		ExportedRoots:   true,
+		Severities: map[Code]Severity{
+			CodeTestOnly:         SeverityError,
+			CodeUnused:           SeverityError,
+			CodeStaleSuppression: staleSeverity,
+			CodeUnexportable:     SeverityError,
+			CodeUnreachable:      SeverityError,
+		},
+	}
+}
+
+// extend returns config with exclusions of cfg added and kinds and severities overridden.
+func (c *dirConfig) extend(cfg fileConfig, configFile, dir string) *dirConfig {
+	res := &dirConfig{
+		ExcludedPaths:   slices.Concat(c.ExcludedPaths, cfg.ExcludedPaths),
+		ExcludedSymbols: slices.Clone(c.ExcludedSymbols),
+		Kinds:           c.Kinds,
+		Severities:      maps.Clone(c.Severities),
+		Interfaces:      slices.Concat(c.Interfaces, cfg.Interfaces),
+		Roots:           slices.Concat(c.Roots, cfg.Roots),
+		ExportedRoots:   c.ExportedRoots,
+	}
+	if cfg.ExportedRoots != nil {
+		res.ExportedRoots = *cfg.ExportedRoots
+	}
+	for _, e := range cfg.ExcludedSymbols {
+		res.ExcludedSymbols = append(res.ExcludedSymbols, symbolExclusion{e, configFile, dir})
+	}
+	if len(cfg.Kinds) > 0 {
+		res.Kinds = cfg.Kinds
+	}
+	maps.Copy(res.Severities, cfg.Severities)
+	return res
+}
+
+func (c *dirConfig) checksKind(kind string) bool {
+	return len(c.Kinds) == 0 || slices.Contains(c.Kinds, kind)
+}
+
+// configFor returns effective config for directory relative to workspace,
+// loading nested config files of directory and its parents.
+func (f *Filter) configFor(dir string) (*dirConfig, error) {
+	if c, ok := f.configs[dir]; ok {
+		return c, nil
+	}
+
+	base := defaultDirConfig(f.cfg.FailOnStale)
+	if dir == "." {
+		c := base.extend(f.cfg.Config, f.cfg.ConfigFile, dir)
+		f.configs[dir] = c
+		f.exclusions = append(f.exclusions, c.ExcludedSymbols...)
+		return c, nil
+	}
+
+	parent, err := f.configFor(path.Dir(dir))
+	if err != nil {
+		return nil, err
+	}
+
+	c := parent
+	for _, name := range ConfigFilenames {
+		filename := filepath.Join(f.cfg.WorkspaceDir, filepath.FromSlash(dir), name)
+		if _, err := os.Stat(filename); errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, errors.Wrap(err, "check nested config file")
+		}
+		if filename == f.cfg.ConfigFile {
+			// root config given with -config flag, already applied
+			break
+		}
+
+		cfg, err := readYAMLConfig(filename, dir)
+		if err != nil {
+			return nil, fmt.Errorf("read nested config file: %w", err)
+		}
+		if !cfg.NoInherit {
+			base = parent
+		}
+		c = base.extend(cfg, filename, dir)
+		f.exclusions = append(f.exclusions, c.ExcludedSymbols[len(base.ExcludedSymbols):]...)
+		break
+	}
+
+	f.configs[dir] = c
+	return c, nil
+}
+
+// configForURI returns effective config for directory of document.
+func (f *Filter) configForURI(uri lsp.URI) (*dirConfig, error) {
+	filename, err := relPath(f.cfg.WorkspaceDir, uri)
+	if err != nil {
+		return nil, err
+	}
+	return f.configFor(path.Dir(filename))
+}
+
+// ValidateConfig checks config file, returning error describing the first problem found.
+// Config in subdirectory of workspace is checked as nested config, the same way
+// as analysis of workspace reads it, others are checked as root config.
+func ValidateConfig(filename, workspaceDir string) error {
+	wd, err := filepath.Abs(workspaceDir)
+	if err != nil {
+		return errors.Wrap(err, "get workspace directory")
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return errors.Wrap(err, "get config file path")
+	}
+
+	dir := ""
+	if rel, err := filepath.Rel(wd, filepath.Dir(abs)); err == nil && rel != "." && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		dir = filepath.ToSlash(rel)
+	}
+	_, err = readYAMLConfig(filename, dir)
+	return err
+}
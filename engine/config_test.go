@@ -0,0 +1,232 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gobwas/glob"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/rprtr258/punused/internal/lsp"
+)
+
+func TestParseConfig(t *testing.T) {
+	c, err := parseConfig([]byte(`
+version: 1
+timeout: 5m
+exclude:
+  paths:
+    - pkg/api/grpc/*.pb.go
+    - internal/myapp/logic/**
+  symbols:
+    - (*UserLogic).SendExampleLogic
+`), "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if c.Timeout != 5*time.Minute {
+		t.Errorf("expected timeout 5m, got %v", c.Timeout)
+	}
+	if len(c.ExcludedPaths) != 2 || !c.ExcludedPaths[0].Match("pkg/api/grpc/a.pb.go") || !c.ExcludedPaths[1].Match("internal/myapp/logic/x/y.go") {
+		t.Errorf("unexpected excluded paths: %v", c.ExcludedPaths)
+	}
+	if len(c.ExcludedSymbols) != 1 || c.ExcludedSymbols[0] != (excludedSymbol{"(*UserLogic).SendExampleLogic", lsp.Position{Line: 8, Character: 6}}) {
+		t.Errorf("unexpected excluded symbols: %v", c.ExcludedSymbols)
+	}
+
+	c, err = parseConfig([]byte(`{"timeout": "1m", "exclude": {"symbols": ["A"]}}`), "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if c.Timeout != time.Minute || len(c.ExcludedSymbols) != 1 {
+		t.Errorf("unexpected JSON config: %+v", c)
+	}
+
+	c, err = parseConfig(nil, "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if c.Timeout != _defaultTimeout {
+		t.Errorf("expected default timeout for empty config, got %v", c.Timeout)
+	}
+
+	for _, test := range []struct {
+		config string
+		errMsg string
+	}{
+		{"version: 2", "unsupported config version 2"},
+		{"timeout: 5", `line 1: invalid duration "5"`},
+		{"timeout: -1m", "line 1: duration must be positive"},
+		{"exclude:\n  path:\n    - a", "line 2: field path not found"},
+		{"exclude:\n  paths:\n    - a\n    - '[a'", `line 4: invalid glob "[a"`},
+		{"exclude:\n  paths:\n    - a: b", "line 3: exclude.paths entry must be a string"},
+		{"kinds:\n  - function\n  - type", `line 3: unknown symbol kind "type"`},
+		{"severity:\n  EU1002: fatal", `line 2: unknown severity "fatal"`},
+		{"severity:\n  EU9999: off", `line 2: unknown diagnostic code "EU9999"`},
+		{"severity: off", "line 1: severity must be a mapping"},
+		{"inherit: maybe", "line 1: cannot unmarshal"},
+		{"interfaces:\n  - name: a.B\n    methods:\n      - Init", `line 4: invalid method "Init" of interface "a.B"`},
+	} {
+		_, err := parseConfig([]byte(test.config), "")
+		if err == nil || !strings.Contains(err.Error(), test.errMsg) {
+			t.Errorf("%q: expected error containing %q, got %v", test.config, test.errMsg, err)
+		}
+	}
+}
+
+func TestFindConfig(t *testing.T) {
+	root := t.TempDir()
+	repo := filepath.Join(root, "repo")
+	ws := filepath.Join(repo, "a", "b")
+	for _, dir := range []string{filepath.Join(repo, ".git"), ws} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	write := func(filename string) {
+		if err := os.WriteFile(filename, nil, 0o644); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	find := func(want string) {
+		t.Helper()
+		got, err := FindConfig(ws)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if got != want {
+			t.Errorf("expected config %q, got %q", want, got)
+		}
+	}
+
+	// config outside of repository is not used
+	write(filepath.Join(root, ".punused.yaml"))
+	find("")
+
+	write(filepath.Join(repo, ".punused.json"))
+	find(filepath.Join(repo, ".punused.json"))
+
+	write(filepath.Join(repo, "a", ".punused.yml"))
+	find(filepath.Join(repo, "a", ".punused.yml"))
+
+	write(filepath.Join(repo, "a", ".punused.yaml"))
+	find(filepath.Join(repo, "a", ".punused.yaml"))
+}
+
+func TestNestedConfig(t *testing.T) {
+	ws := t.TempDir()
+	write := func(filename, content string) {
+		filename = filepath.Join(ws, filepath.FromSlash(filename))
+		if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+			t.Fatal(err.Error())
+		}
+		if err := os.WriteFile(filename, []byte(content), 0o644); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	write("a/.punused.yaml", `
+kinds: [function]
+severity:
+  EU1002: warning
+exclude:
+  paths: ["gen/**"]
+  symbols: [A]
+`)
+	write("a/b/.punused.yml", `
+severity:
+  EU1001: off
+exclude:
+  symbols: [B]
+`)
+	write("c/.punused.json", `{"inherit": false, "exclude": {"symbols": ["C"]}}`)
+
+	root, err := parseConfig([]byte("exclude:\n  paths: [a/skip.go]\n  symbols: [R]"), "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	r := &runner{Filter: newFilter(runConfig{
+		ConfigFile:       filepath.Join(ws, ".punused.yaml"),
+		WorkspaceDir:     ws,
+		FilenameMatchers: []glob.Glob{glob.MustCompile("**.go")},
+		Config:           root,
+	})}
+
+	for filename, want := range map[string]bool{
+		"main.go":        false,
+		"a/skip.go":      true,
+		"a/gen/x.go":     true,
+		"a/b/gen/x.go":   false,
+		"gen/x.go":       false,
+		"c/skip.go":      false,
+		"a/b/c/d/ok.go":  false,
+		"a/b/c/gen/x.go": false,
+	} {
+		got, err := r.isFileExcluded(filename)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if got != want {
+			t.Errorf("%s: expected excluded=%t, got %t", filename, want, got)
+		}
+	}
+
+	for dir, want := range map[string]struct {
+		symbols    []string
+		kinds      []string
+		severities map[Code]Severity
+	}{
+		".":     {[]string{"R"}, nil, map[Code]Severity{CodeTestOnly: SeverityError, CodeUnused: SeverityError, CodeStaleSuppression: SeverityWarning, CodeUnexportable: SeverityError, CodeUnreachable: SeverityError}},
+		"a/b/c": {[]string{"R", "A", "B"}, []string{"function"}, map[Code]Severity{CodeTestOnly: SeverityOff, CodeUnused: SeverityWarning, CodeStaleSuppression: SeverityWarning, CodeUnexportable: SeverityError, CodeUnreachable: SeverityError}},
+		"c":     {[]string{"C"}, nil, map[Code]Severity{CodeTestOnly: SeverityError, CodeUnused: SeverityError, CodeStaleSuppression: SeverityWarning, CodeUnexportable: SeverityError, CodeUnreachable: SeverityError}},
+	} {
+		cfg, err := r.configFor(dir)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		var symbols []string
+		for _, e := range cfg.ExcludedSymbols {
+			symbols = append(symbols, e.Name)
+		}
+		if diff := cmp.Diff(want.symbols, symbols); diff != "" {
+			t.Errorf("%s: unexpected excluded symbols\n%s", dir, diff)
+		}
+		if diff := cmp.Diff(want.kinds, cfg.Kinds); diff != "" {
+			t.Errorf("%s: unexpected kinds\n%s", dir, diff)
+		}
+		if diff := cmp.Diff(want.severities, cfg.Severities); diff != "" {
+			t.Errorf("%s: unexpected severities\n%s", dir, diff)
+		}
+	}
+
+	// every exclusion is tracked once for stale suppressions
+	if len(r.exclusions) != 4 {
+		t.Errorf("expected 4 tracked exclusions, got %v", r.exclusions)
+	}
+
+	if _, err := parseConfig([]byte("timeout: 1m"), "a"); err == nil {
+		t.Error("expected error for timeout in nested config")
+	}
+}
+
+func TestValidateConfig(t *testing.T) {
+	ws := t.TempDir()
+	filename := filepath.Join(ws, "sub", ".punused.yaml")
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := os.WriteFile(filename, []byte("timeout: 1m\n"), 0o644); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// the same config is nested in workspace, but is root config of its own directory
+	if err := ValidateConfig(filename, ws); err == nil || !strings.Contains(err.Error(), "only in root config") {
+		t.Errorf("expected nested config to be rejected, got %v", err)
+	}
+	if err := ValidateConfig(filename, filepath.Dir(filename)); err != nil {
+		t.Errorf("expected root config to be valid, got %v", err)
+	}
+}
@@ -0,0 +1,390 @@
+// Package engine finds unused symbols of Go module the same way punused
+// command does, so that punused can be run programmatically:
+//
+//	for f, err := range engine.Analyze(ctx, engine.Options{WorkspaceDir: dir}) {
+//		if err != nil {
+//			return err
+//		}
+//		fmt.Println(f)
+//	}
+//
+// Types of this package are stable: fields and constants may be added, but
+// existing ones are not changed or removed.
+package engine
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io"
+	"iter"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"runtime"
+
+	"github.com/gobwas/glob"
+	"github.com/pkg/errors"
+
+	"github.com/rprtr258/punused/internal/lsp"
+)
+
+// _defaultPattern matches every go file in the workspace.
+const _defaultPattern = "**/*.go"
+
+// Options configure analysis, zero value of every field means default.
+type Options struct {
+	// WorkspaceDir is root of Go module to check, current directory by default.
+	WorkspaceDir string
+	// ConfigFile is config file to use, if empty, it is searched from workspace
+	// directory upward, default config is used if none is found.
+	ConfigFile string
+	// Patterns are globs of files to check, relative to workspace directory,
+	// file is checked if it matches any of them. Default is "**/*.go".
+	Patterns []string
+	// SkipTests makes _test.go files not checked, references from them are still counted.
+	SkipTests bool
+	// ReportUnexportable enables reporting of exported symbols used only in their own package.
+	ReportUnexportable bool
+	// FailOnStale makes stale suppressions errors, unless severity is set in config.
+	FailOnStale bool
+	// Backend finds symbols and references, gopls by default.
+	Backend Backend
+	// Concurrency is max number of reference queries in flight, number of CPUs by default.
+	Concurrency int
+	// Since is git revision, if set, only files changed since it are checked, as well as
+	// symbols of other files, which lost their last references in changed files. Unreachable
+	// symbols and stale exclusions of config are not reported then. Explain and Serve don't support it.
+	Since string
+}
+
+// Position in file, line and column are 1-based. Column counts UTF-16 code units, as LSP does.
+type Position struct {
+	Line, Column int
+}
+
+// Location is a range in file.
+type Location struct {
+	// Path is slash separated path of file relative to workspace directory.
+	Path       string
+	Start, End Position
+}
+
+// Finding is a diagnostic about symbol or, for stale suppressions, about suppression.
+type Finding struct {
+	// Location of symbol name or of suppression.
+	Location Location
+	// Kind of symbol in lower case, e.g. function, field or class, suppression for stale suppressions.
+	Kind string
+	// Name of symbol as gopls names it, e.g. (*MyType).MyMethod or MyField, or text of suppression.
+	Name string
+	// QualifiedName is Name prefixed with enclosing symbols, e.g. (MyType).MyField.
+	QualifiedName string
+	// Detail is signature or type of symbol, if known.
+	Detail   string
+	Code     Code
+	Severity Severity
+	// References found for symbol, empty for unused symbols.
+	References []Location
+
+	// symbol is kept to fix finding.
+	symbol symbol
+}
+
+// Message returns description of finding, e.g. "unused".
+func (f Finding) Message() string {
+	return f.Code.Message()
+}
+
+// String formats finding the way punused prints it, e.g.
+// "pkg/a.go:7:2 variable UnusedVar is unused (EU1002)".
+func (f Finding) String() string {
+	return fmt.Sprintf("%s:%d:%d %s %s is %s (%s)",
+		f.Location.Path, f.Location.Start.Line, f.Location.Start.Column, f.Kind, f.Name, f.Message(), f.Code)
+}
+
+// Fixable reports whether Fix can fix finding: unused symbols are removed and
+// symbols used only in their own package are unexported. Findings not returned by Analyze are not fixable.
+func (f Finding) Fixable() bool {
+	return f.symbol.URI != "" && (&fixer{symbols: map[lsp.URI][]symbol{}}).add(diagnostic{Symbol: f.symbol, Code: f.Code})
+}
+
+func newLocation(workspaceDir string, loc lsp.Location) (Location, error) {
+	path, err := relPath(workspaceDir, loc.URI)
+	if err != nil {
+		return Location{}, err
+	}
+	return Location{
+		Path:  path,
+		Start: Position{loc.Range.Start.Line + 1, loc.Range.Start.Character + 1},
+		End:   Position{loc.Range.End.Line + 1, loc.Range.End.Character + 1},
+	}, nil
+}
+
+func (r *runner) finding(diag diagnostic) (Finding, error) {
+	s := diag.Symbol
+	loc, err := newLocation(r.cfg.WorkspaceDir, lsp.Location{URI: s.URI, Range: s.SelectionRange})
+	if err != nil {
+		return Finding{}, err
+	}
+
+	refs := make([]Location, len(diag.References))
+	for i, ref := range diag.References {
+		if refs[i], err = newLocation(r.cfg.WorkspaceDir, ref); err != nil {
+			return Finding{}, err
+		}
+	}
+
+	return Finding{
+		Location:      loc,
+		Kind:          diag.kind(),
+		Name:          s.Name,
+		QualifiedName: s.QualifiedName(),
+		Detail:        s.Detail,
+		Code:          diag.Code,
+		Severity:      diag.Severity,
+		References:    refs,
+		symbol:        s,
+	}, nil
+}
+
+// newRunConfig resolves options and reads config.
+func newRunConfig(opts Options) (runConfig, error) {
+	wd, err := filepath.Abs(cmp.Or(opts.WorkspaceDir, "."))
+	if err != nil {
+		return runConfig{}, errors.Wrap(err, "get workspace directory")
+	}
+
+	patterns := opts.Patterns
+	if len(patterns) == 0 {
+		patterns = []string{_defaultPattern}
+	}
+	matchers := make([]glob.Glob, len(patterns))
+	for i, pattern := range patterns {
+		if matchers[i], err = glob.Compile(pattern); err != nil {
+			return runConfig{}, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+		}
+	}
+
+	configFile := opts.ConfigFile
+	if configFile == "" {
+		if configFile, err = FindConfig(wd); err != nil {
+			return runConfig{}, err
+		}
+	}
+
+	config := defaultConfig()
+	if configFile != "" {
+		if configFile, err = filepath.Abs(configFile); err != nil {
+			return runConfig{}, errors.Wrap(err, "get config file path")
+		}
+		if config, err = readYAMLConfig(configFile, ""); err != nil {
+			return runConfig{}, fmt.Errorf("read config file: %w", err)
+		}
+	}
+
+	// This needs to be run from the rooot of a Go Module to get correct results.
+	if _, err := os.Stat(filepath.Join(wd, "go.mod")); err != nil {
+		return runConfig{}, fmt.Errorf("workspace %s is not a Go module (go.mod is missing): %w", wd, err)
+	}
+
+	return runConfig{
+		ConfigFile:         configFile,
+		WorkspaceDir:       wd,
+		FilenameMatchers:   matchers,
+		Config:             config,
+		SkipTests:          opts.SkipTests,
+		ReportUnexportable: opts.ReportUnexportable,
+		FailOnStale:        opts.FailOnStale,
+		Concurrency:        cmp.Or(opts.Concurrency, runtime.NumCPU()),
+	}, nil
+}
+
+// logConfigFile logs which config file is used.
+func (cfg runConfig) logConfigFile() {
+	if cfg.ConfigFile == "" {
+		slog.Info("no config file found, using default config")
+	} else {
+		slog.Info("using config file", "file", cfg.ConfigFile)
+	}
+}
+
+// newRunner creates runner using client and opens files to check in it.
+func newRunner(cfg runConfig, client backend) (*runner, error) {
+	r := &runner{
+		Filter:       newFilter(cfg),
+		client:       client,
+		cancel:       func() {},
+		packageNames: map[lsp.URI]string{},
+		fset:         token.NewFileSet(),
+		parsedFiles:  map[lsp.URI]*ast.File{},
+		nodes:        map[nodeKey]*node{},
+		current:      nil,
+		lookups:      map[nodeKey]*lookup{},
+		since:        nil,
+	}
+
+	// files are opened upfront, so that references from any of them are found
+	for filename, err := range r.Walk {
+		if err != nil {
+			return nil, err
+		}
+		if err := client.Open(filename); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// start reads config, starts backend and opens files to check in it. If Since is set,
+// workspace at revision is checked out and analyzed too. Runner must be stopped after use.
+func start(ctx context.Context, opts Options) (*runner, error) {
+	cfg, err := newRunConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	cfg.logConfigFile()
+
+	ctx, cancel := context.WithTimeout(ctx, cfg.Config.Timeout)
+	kind, concurrency := cmp.Or(opts.Backend, BackendGopls), cfg.Concurrency
+	client, err := newBackend(ctx, kind, cfg.WorkspaceDir, concurrency)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	r, err := newRunner(cfg, client)
+	if err != nil {
+		_ = client.Close()
+		cancel()
+		return nil, err
+	}
+	r.cancel = cancel
+
+	if opts.Since != "" {
+		if r.since, err = newRevision(ctx, cfg, kind, concurrency, opts.Since); err != nil {
+			_ = r.Stop()
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// Analyze checks files of workspace and yields findings: unused symbols as soon as they
+// are found, then unreachable symbols and stale suppressions. Findings with severity off
+// and suppressed ones are not yielded. Iteration stops after the first error.
+func Analyze(ctx context.Context, opts Options) iter.Seq2[Finding, error] {
+	return func(yield func(Finding, error) bool) {
+		r, err := start(ctx, opts)
+		if err != nil {
+			yield(Finding{}, err)
+			return
+		}
+
+		stopped := false
+		r.analyze(func(diag diagnostic, err error) bool {
+			var f Finding
+			if err == nil {
+				f, err = r.finding(diag)
+			}
+			stopped = !yield(f, err) || err != nil
+			return !stopped
+		})
+		if err := r.Stop(); err != nil && !stopped {
+			yield(Finding{}, err)
+		}
+	}
+}
+
+// Explain writes why symbol given as <pkg>.<Symbol> is considered used or not: diagnostics
+// reported for it, its references and path to it from root. Package is given by name or
+// by directory relative to workspace, e.g. internal/store.(*DB).Close, see SplitTarget.
+func Explain(ctx context.Context, opts Options, target string, w io.Writer) (err error) {
+	if _, _, err := SplitTarget(target); err != nil {
+		return err
+	}
+	if opts.Since != "" {
+		return errors.New("explain needs reference graph of whole workspace and can't check changes since revision")
+	}
+
+	r, err := start(ctx, opts)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if errStop := r.Stop(); err == nil {
+			err = errStop
+		}
+	}()
+
+	var diags []diagnostic
+	var errAnalyze error
+	g := r.analyze(func(diag diagnostic, err error) bool {
+		if err != nil {
+			errAnalyze = err
+			return false
+		}
+		diags = append(diags, diag)
+		return true
+	})
+	if errAnalyze != nil {
+		return errAnalyze
+	}
+	return r.explain(target, g, diags, w)
+}
+
+// Fix fixes findings reported by Analyze run with the same options, see Finding.Fixable.
+// Fixed files are formatted and imports, which became unused, are removed. If w is not nil,
+// files are not changed, unified diff is written to w instead. Findings which can't be
+// fixed are returned. Unexporting symbols requires gopls backend.
+func Fix(ctx context.Context, opts Options, findings []Finding, w io.Writer) (_ []Finding, err error) {
+	wd, err := filepath.Abs(cmp.Or(opts.WorkspaceDir, "."))
+	if err != nil {
+		return nil, errors.Wrap(err, "get workspace directory")
+	}
+
+	var skipped []Finding
+	byLocation := map[lsp.Location]Finding{}
+	fix := &fixer{wd, nil, map[lsp.URI][]symbol{}, nil}
+	for _, f := range findings {
+		if !f.Fixable() {
+			skipped = append(skipped, f)
+			continue
+		}
+		fix.add(diagnostic{Symbol: f.symbol, Code: f.Code})
+		byLocation[lsp.Location{URI: f.symbol.URI, Range: f.symbol.SelectionRange}] = f
+	}
+
+	// symbols are renamed by gopls, which needs files of workspace opened
+	if len(fix.renames) > 0 {
+		if b := cmp.Or(opts.Backend, BackendGopls); b != BackendGopls {
+			return nil, errors.Errorf("%s backend can't unexport symbols, %s backend is required", b, BackendGopls)
+		}
+
+		// changes since revision are not needed to rename symbols
+		opts.Since = ""
+		r, err := start(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		defer func() {
+			if errStop := r.Stop(); err == nil {
+				err = errStop
+			}
+		}()
+		fix.client = r.client.(renamer)
+	}
+
+	unfixed, err := fix.apply(w != nil, w)
+	if err != nil {
+		return nil, err
+	}
+	for _, diag := range unfixed {
+		f := byLocation[lsp.Location{URI: diag.Symbol.URI, Range: diag.Symbol.SelectionRange}]
+		slog.Warn("can't fix automatically", "symbol", f.QualifiedName)
+		skipped = append(skipped, f)
+	}
+	return skipped, nil
+}
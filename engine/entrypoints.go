@@ -0,0 +1,49 @@
+package engine
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/rprtr258/punused/internal/exclude"
+	"github.com/rprtr258/punused/internal/lsp"
+)
+
+// parsedFile returns AST of go file, files are parsed once.
+func (r *runner) parsedFile(uri lsp.URI) (*token.FileSet, *ast.File, error) {
+	if f, ok := r.parsedFiles[uri]; ok {
+		return r.fset, f, nil
+	}
+
+	f, err := parser.ParseFile(r.fset, strings.TrimPrefix(string(uri), "file://"), nil, parser.SkipObjectResolution)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "parse file")
+	}
+	r.parsedFiles[uri] = f
+	return r.fset, f, nil
+}
+
+// isEntrypoint checks whether function is called by go toolchain, see exclude.IsEntrypoint.
+func (r *runner) isEntrypoint(s symbol) (bool, error) {
+	fset, f, err := r.parsedFile(s.URI)
+	if err != nil {
+		return false, err
+	}
+
+	for _, decl := range f.Decls {
+		if decl, ok := decl.(*ast.FuncDecl); ok && decl.Recv == nil &&
+			decl.Name.Name == s.Name && fset.Position(decl.Name.Pos()).Line-1 == s.SelectionRange.Start.Line {
+			// without types, e.g. with gopls, parameter type is matched syntactically
+			var sig *types.Signature
+			if resolver, ok := r.client.(typeResolver); ok {
+				sig, _ = resolver.TypeOf(lsp.Location{URI: s.URI, Range: s.SelectionRange}).(*types.Signature)
+			}
+			return exclude.IsEntrypoint(string(s.URI), f, decl, sig), nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,65 @@
+package engine_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/rprtr258/punused/engine"
+)
+
+func ExampleAnalyze() {
+	for f, err := range engine.Analyze(context.Background(), engine.Options{
+		WorkspaceDir: "..",
+		Patterns:     []string{"testdata/firstpackage/code1.go"},
+		Backend:      engine.BackendPackages,
+	}) {
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		fmt.Println(f)
+	}
+	// Output:
+	// testdata/firstpackage/code1.go:7:2 variable UnusedVar is unused (EU1002)
+	// testdata/firstpackage/code1.go:12:2 constant UnusedConst is unused (EU1002)
+	// testdata/firstpackage/code1.go:19:6 function UnusedFunction is unused (EU1002)
+	// testdata/firstpackage/code1.go:25:2 field UnusedField is unused (EU1002)
+	// testdata/firstpackage/code1.go:32:15 method (MyType).UnusedMethod is unused (EU1002)
+	// testdata/firstpackage/code1.go:36:6 interface UnusedInterfaceWithUsedAndUnusedMethod is unused (EU1002)
+	// testdata/firstpackage/code1.go:37:2 method UsedInterfaceMethodReturningInt is unused (EU1002)
+	// testdata/firstpackage/code1.go:38:2 method UnusedInterfaceMethodReturningInt is unused (EU1002)
+	// testdata/firstpackage/code1.go:41:6 interface UnusedInterface is unused (EU1002)
+	// testdata/firstpackage/code1.go:42:2 method UnusedInterfaceReturningInt is unused (EU1002)
+	// testdata/firstpackage/code1.go:45:6 interface UsedInterface is unused (EU1002)
+}
+
+func ExampleFix() {
+	ctx := context.Background()
+	opts := engine.Options{
+		WorkspaceDir: "..",
+		Patterns:     []string{"testdata/firstpackage/code1.go"},
+		Backend:      engine.BackendPackages,
+	}
+
+	var findings []engine.Finding
+	for f, err := range engine.Analyze(ctx, opts) {
+		if err != nil {
+			fmt.Println(err)
+			return
+		}
+		if f.Fixable() {
+			findings = append(findings, f)
+		}
+	}
+
+	// files are not changed, since diff is written to io.Discard instead
+	skipped, err := engine.Fix(ctx, opts, findings, io.Discard)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("%d of %d findings can be fixed\n", len(findings)-len(skipped), len(findings))
+	// Output:
+	// 11 of 11 findings can be fixed
+}
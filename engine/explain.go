@@ -0,0 +1,153 @@
+package engine
+
+import (
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/rprtr258/punused/internal/lsp"
+)
+
+// SplitTarget splits explain target <pkg>.<Symbol> into package and qualified symbol name,
+// e.g. internal/store.(*DB).Close into internal/store and (*DB).Close.
+func SplitTarget(target string) (string, string, error) {
+	slash := strings.LastIndex(target, "/") + 1
+	pkg, name, ok := strings.Cut(target[slash:], ".")
+	if !ok || pkg == "" || name == "" {
+		return "", "", errors.Errorf("invalid symbol %q, must be in form <pkg>.<Symbol>", target)
+	}
+	return target[:slash] + pkg, name, nil
+}
+
+// isInPackage checks whether document is in package given either by name or
+// by directory relative to workspace, or by trailing part of such directory.
+func (r *runner) isInPackage(uri lsp.URI, pkg string) (bool, error) {
+	filename, err := relPath(r.cfg.WorkspaceDir, uri)
+	if err != nil {
+		return false, err
+	}
+	if dir := path.Dir(filename); dir == pkg || strings.HasSuffix(dir, "/"+pkg) {
+		return true, nil
+	}
+
+	name, err := r.packageName(uri)
+	if err != nil {
+		return false, err
+	}
+	return name == pkg, nil
+}
+
+// explain writes why symbol given as <pkg>.<Symbol> is considered used or not: its references
+// with enclosing symbols, path to it from root and diagnostics reported for it.
+// Must be called only after all diagnostics are evaluated.
+func (r *runner) explain(target string, g *graph, diags []diagnostic, w io.Writer) error {
+	pkg, name, err := SplitTarget(target)
+	if err != nil {
+		return err
+	}
+
+	var find func(n *node, s symbol) error
+	found := 0
+	find = func(n *node, s symbol) error {
+		if s.QualifiedName() == name {
+			found++
+			if err := r.explainSymbol(g, n, s, diags, w); err != nil {
+				return err
+			}
+		}
+		for _, ch := range s.Children {
+			if err := find(n, symbol{ch, s.URI, s.QualifiedName()}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	for _, n := range g.nodes {
+		if ok, err := r.isInPackage(n.Symbol.URI, pkg); err != nil {
+			return err
+		} else if !ok {
+			continue
+		}
+
+		if err := find(n, n.Symbol); err != nil {
+			return err
+		}
+	}
+
+	if found == 0 {
+		return errors.Errorf("symbol %s not found in package %s among checked files", name, pkg)
+	}
+	return nil
+}
+
+func (r *runner) explainSymbol(g *graph, n *node, s symbol, diags []diagnostic, w io.Writer) error {
+	describe := func(s symbol) (string, error) {
+		filename, err := relPath(r.cfg.WorkspaceDir, s.URI)
+		if err != nil {
+			return "", err
+		}
+		loc := s.SelectionRange.Start
+		return fmt.Sprintf("%s:%d:%d %s %s", filename, loc.Line+1, loc.Character+1, strings.ToLower(s.Kind.String()), s.QualifiedName()), nil
+	}
+
+	header, err := describe(s)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(w, header)
+
+	fmt.Fprintln(w, "diagnostics:")
+	reported := false
+	for _, diag := range diags {
+		if diag.Symbol.URI == s.URI && diag.Symbol.SelectionRange == s.SelectionRange {
+			reported = true
+			fmt.Fprintf(w, "\t%s (%s), %s\n", diag.Code.Message(), diag.Code, diag.Severity)
+		}
+	}
+	if !reported {
+		fmt.Fprintln(w, "\tnone")
+	}
+
+	refs, _, err := r.references(s)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "references (%d):\n", len(refs))
+	for _, ref := range refs {
+		filename, err := relPath(r.cfg.WorkspaceDir, ref.URI)
+		if err != nil {
+			return err
+		}
+		user := "outside of checked symbols"
+		if enclosing := g.enclosing(ref); enclosing != nil {
+			user = "in " + strings.ToLower(enclosing.Symbol.Kind.String()) + " " + enclosing.Symbol.Name
+		}
+		test := ""
+		if strings.HasSuffix(filename, "_test.go") {
+			test = " (test)"
+		}
+		fmt.Fprintf(w, "\t%s:%d:%d %s%s\n", filename, ref.Range.Start.Line+1, ref.Range.Start.Character+1, user, test)
+	}
+
+	path := g.pathFromRoot(n)
+	if path == nil {
+		fmt.Fprintln(w, "not reachable from any root")
+		return nil
+	}
+	fmt.Fprintln(w, "path from root:")
+	for i, p := range path {
+		line, err := describe(p.Symbol)
+		if err != nil {
+			return err
+		}
+		if i == 0 {
+			line += " (root: " + p.RootReason + ")"
+		}
+		fmt.Fprintln(w, "\t"+line)
+	}
+	return nil
+}
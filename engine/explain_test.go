@@ -0,0 +1,26 @@
+package engine
+
+import "testing"
+
+func TestSplitTarget(t *testing.T) {
+	for target, want := range map[string][2]string{
+		"main.run":                        {"main", "run"},
+		"firstpackage.(MyType).UsedField": {"firstpackage", "(MyType).UsedField"},
+		"internal/store.(*DB).Close":      {"internal/store", "(*DB).Close"},
+		"a.b/c.d.E":                       {"a.b/c", "d.E"},
+	} {
+		pkg, name, err := SplitTarget(target)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if pkg != want[0] || name != want[1] {
+			t.Errorf("%s: expected %q %q, got %q %q", target, want[0], want[1], pkg, name)
+		}
+	}
+
+	for _, target := range []string{"Symbol", ".Symbol", "pkg.", "a.b/"} {
+		if _, _, err := SplitTarget(target); err == nil {
+			t.Errorf("%s: expected error", target)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package engine
+
+import (
+	"fmt"
+	"log/slog"
+	"path"
+	"path/filepath"
+
+	"github.com/rprtr258/punused/internal/exclude"
+	"github.com/rprtr258/punused/internal/lsp"
+)
+
+// Filter applies config and suppression directives of workspace to symbols, deciding
+// which of them are checked and which diagnostics are suppressed. It is shared by punused
+// and analyzer, so that both report the same diagnostics. Filter is not safe for concurrent use.
+type Filter struct {
+	cfg runConfig
+	// directives found in already visited files
+	directives map[lsp.URI][]directive
+	// configs are effective configs by directory relative to workspace
+	configs map[string]*dirConfig
+	// exclusions are exclude.symbols entries of all loaded configs
+	exclusions []symbolExclusion
+	// matchedExclusions are exclusions which suppressed at least one diagnostic
+	matchedExclusions map[symbolExclusion]bool
+	// skippedExclusions are exclusions applying to symbols which are not analyzed, e.g. of kinds not checked
+	skippedExclusions map[symbolExclusion]bool
+	// excludedDirs are directories of files left out of analysis by patterns or exclude.paths,
+	// symbols of exclusions can be declared there
+	excludedDirs map[string]bool
+}
+
+func newFilter(cfg runConfig) *Filter {
+	return &Filter{
+		cfg:               cfg,
+		directives:        map[lsp.URI][]directive{},
+		configs:           map[string]*dirConfig{},
+		exclusions:        nil,
+		matchedExclusions: map[symbolExclusion]bool{},
+		skippedExclusions: map[symbolExclusion]bool{},
+		excludedDirs:      map[string]bool{},
+	}
+}
+
+// NewFilter reads config of workspace, see Options.WorkspaceDir and Options.ConfigFile.
+func NewFilter(opts Options) (*Filter, error) {
+	cfg, err := newRunConfig(opts)
+	if err != nil {
+		return nil, err
+	}
+	return newFilter(cfg), nil
+}
+
+// Symbol is declaration Filter is applied to, named the same way as in findings.
+type Symbol struct {
+	// Filename is absolute path of file symbol is declared in.
+	Filename string
+	// Line is zero-based line of symbol name.
+	Line int
+	// Kind is kind of symbol as in config, e.g. struct.
+	Kind string
+	// Name is name of symbol, e.g. (*T).Method for methods.
+	Name string
+	// Parent is qualified name of enclosing symbol, e.g. T for fields of struct T, empty for top level symbols.
+	Parent string
+}
+
+func (s Symbol) symbol() symbol {
+	pos := lsp.Position{Line: s.Line, Character: 0}
+	return symbol{
+		documentSymbol: documentSymbol{Name: s.Name, Range: lsp.Range{Start: pos, End: pos}, SelectionRange: lsp.Range{Start: pos, End: pos}},
+		URI:            lsp.URI("file://" + filepath.ToSlash(s.Filename)),
+		Parent:         s.Parent,
+	}
+}
+
+func kindExclusionReason(kind string) string {
+	return fmt.Sprintf("kind %s is not checked", kind)
+}
+
+// isPathExcluded checks whether file relative to workspace is excluded by exclude.paths config.
+func (f *Filter) isPathExcluded(filename string) (bool, error) {
+	cfg, err := f.configFor(path.Dir(filename))
+	if err != nil {
+		return false, err
+	}
+
+	for _, excluded := range cfg.ExcludedPaths {
+		if excluded.Match(filename) {
+			slog.Debug("file excluded", "file", filename, "rule", "exclude.paths "+excluded.Pattern)
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Checks checks whether symbol is checked, that is neither its file is excluded
+// by exclude.paths config nor its kind is excluded by kinds config.
+func (f *Filter) Checks(s Symbol) (bool, error) {
+	uri := s.symbol().URI
+	filename, err := relPath(f.cfg.WorkspaceDir, uri)
+	if err != nil {
+		return false, err
+	}
+	if excluded, err := f.isPathExcluded(filename); err != nil || excluded {
+		return false, err
+	}
+
+	cfg, err := f.configForURI(uri)
+	if err != nil {
+		return false, err
+	}
+	if !cfg.checksKind(s.Kind) {
+		slog.Debug("symbol excluded", "symbol", s.symbol().QualifiedName(), "uri", uri, "rule", kindExclusionReason(s.Kind))
+		return false, nil
+	}
+	return true, nil
+}
+
+// Interfaces returns methods of interfaces declared in config applying to file,
+// methods implementing them are not reported, see exclude.IsWellKnownMethod.
+func (f *Filter) Interfaces(filename string) ([]exclude.Method, error) {
+	cfg, err := f.configForURI(Symbol{Filename: filename}.symbol().URI)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Interfaces, nil
+}
+
+// Reports checks whether diagnostic with code about symbol is reported, that is its
+// severity is not off and it is suppressed by neither directive nor exclude.symbols config.
+func (f *Filter) Reports(s Symbol, code Code) (bool, error) {
+	sym := s.symbol()
+	cfg, err := f.configForURI(sym.URI)
+	if err != nil {
+		return false, err
+	}
+	if _, ok := f.directives[sym.URI]; !ok {
+		if f.directives[sym.URI], err = parseDirectives(s.Filename); err != nil {
+			return false, fmt.Errorf("failed to get suppression directives: %w", err)
+		}
+	}
+
+	diag := diagnostic{Symbol: sym, Code: code, References: nil, Severity: cfg.Severities[code]}
+	return diag.Severity != SeverityOff && !f.isSuppressed(cfg, diag), nil
+}
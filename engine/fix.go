@@ -0,0 +1,295 @@
+package engine
+
+import (
+	"bytes"
+	"cmp"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/pmezard/go-difflib/difflib"
+	"github.com/rprtr258/fun"
+	"golang.org/x/tools/imports"
+
+	"github.com/rprtr258/punused/internal/lsp"
+	"github.com/rprtr258/punused/internal/removal"
+)
+
+// offset converts LSP position, which counts characters in UTF-16 code units, to byte offset in src.
+func offset(src []byte, pos lsp.Position) int {
+	off := 0
+	for range pos.Line {
+		i := bytes.IndexByte(src[off:], '\n')
+		if i == -1 {
+			return len(src)
+		}
+		off += i + 1
+	}
+
+	for units := 0; units < pos.Character && off < len(src) && src[off] != '\n'; {
+		r, size := utf8.DecodeRune(src[off:])
+		units += utf16.RuneLen(r)
+		off += size
+	}
+	return off
+}
+
+// removalSpan finds declaration of symbol in file and returns span to remove.
+// Returns false if symbol can't be removed automatically, see removal.At.
+func removalSpan(fset *token.FileSet, f *ast.File, src []byte, s symbol) (removal.Span, bool) {
+	pos := fset.File(f.Pos()).Pos(offset(src, s.SelectionRange.Start))
+	return removal.At(fset, f, pos)
+}
+
+// removeSymbols removes declarations of symbols from go source, then formats it and removes unused imports.
+// Symbols which can't be removed are returned.
+func removeSymbols(filename string, src []byte, symbols []symbol) ([]byte, []symbol, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "parse %s", filename)
+	}
+
+	var spans []removal.Span
+	var skipped []symbol
+	for _, s := range symbols {
+		sp, ok := removalSpan(fset, f, src, s)
+		if !ok {
+			skipped = append(skipped, s)
+			continue
+		}
+		spans = append(spans, removal.ExpandToLines(src, sp))
+	}
+
+	// remove spans contained in others, e.g. fields of removed struct,
+	// then whole group if all its specs are removed
+	slices.SortFunc(spans, func(a, b removal.Span) int {
+		return cmp.Or(cmp.Compare(a.Start, b.Start), cmp.Compare(b.End, a.End))
+	})
+	var merged []removal.Span
+	for _, sp := range spans {
+		if len(merged) > 0 && sp.Start < merged[len(merged)-1].End {
+			merged[len(merged)-1].End = max(merged[len(merged)-1].End, sp.End)
+			continue
+		}
+		merged = append(merged, sp)
+	}
+	merged = removeEmptyGroups(fset, f, src, merged)
+
+	var res bytes.Buffer
+	last := 0
+	for _, sp := range merged {
+		res.Write(src[last:sp.Start])
+		last = sp.End
+	}
+	res.Write(src[last:])
+
+	formatted, err := imports.Process(filename, res.Bytes(), &imports.Options{
+		Comments:   true,
+		TabIndent:  true,
+		TabWidth:   8,
+		FormatOnly: false,
+	})
+	if err != nil {
+		return nil, nil, errors.Wrapf(err, "format %s after removing symbols", filename)
+	}
+	return formatted, skipped, nil
+}
+
+// removeEmptyGroups replaces spans removing all specs of grouped declaration
+// with span removing whole declaration.
+func removeEmptyGroups(fset *token.FileSet, f *ast.File, src []byte, spans []removal.Span) []removal.Span {
+	covered := func(n ast.Node) bool {
+		start, end := fset.Position(n.Pos()).Offset, fset.Position(n.End()).Offset
+		return slices.ContainsFunc(spans, func(sp removal.Span) bool { return sp.Start <= start && end <= sp.End })
+	}
+
+	res := spans
+	for _, decl := range f.Decls {
+		decl, ok := decl.(*ast.GenDecl)
+		if !ok || !decl.Lparen.IsValid() || len(decl.Specs) == 0 || covered(decl) ||
+			!fun.All(func(spec ast.Spec) bool { return covered(spec) }, decl.Specs...) {
+			continue
+		}
+
+		declSp := removal.ExpandToLines(src, removal.Decl(fset, decl, decl.Doc, nil))
+		res = slices.DeleteFunc(res, func(sp removal.Span) bool { return declSp.Start <= sp.Start && sp.End <= declSp.End })
+		res = append(res, declSp)
+	}
+	slices.SortFunc(res, func(a, b removal.Span) int { return cmp.Compare(a.Start, b.Start) })
+	return res
+}
+
+// applyEdits applies non overlapping text edits to src.
+func applyEdits(src []byte, edits []lsp.TextEdit) []byte {
+	edits = slices.Clone(edits)
+	slices.SortFunc(edits, func(a, b lsp.TextEdit) int {
+		return cmp.Or(
+			cmp.Compare(b.Range.Start.Line, a.Range.Start.Line),
+			cmp.Compare(b.Range.Start.Character, a.Range.Start.Character),
+		)
+	})
+
+	res := slices.Clone(src)
+	for _, edit := range edits {
+		start, end := offset(res, edit.Range.Start), offset(res, edit.Range.End)
+		res = slices.Concat(res[:start], []byte(edit.NewText), res[end:])
+	}
+	return res
+}
+
+// shift returns position in text with edits applied, given position in original text.
+// Only edits within a line, which renames are, are taken into account.
+func shift(pos lsp.Position, edits []lsp.TextEdit) lsp.Position {
+	res := pos
+	for _, edit := range edits {
+		if rng := edit.Range; rng.Start.Line == pos.Line && rng.End.Line == pos.Line && rng.End.Character <= pos.Character {
+			res.Character += utf16Len([]byte(edit.NewText)) - (rng.End.Character - rng.Start.Character)
+		}
+	}
+	return res
+}
+
+// shiftSymbol returns symbol with ranges moved by edits, see shift.
+func shiftSymbol(s documentSymbol, edits []lsp.TextEdit) documentSymbol {
+	s.Range = lsp.Range{Start: shift(s.Range.Start, edits), End: shift(s.Range.End, edits)}
+	s.SelectionRange = lsp.Range{Start: shift(s.SelectionRange.Start, edits), End: shift(s.SelectionRange.End, edits)}
+	return s
+}
+
+// fixer removes unused symbols and unexports symbols used only in their package.
+type fixer struct {
+	workspaceDir string
+	client       renamer
+	// symbols to remove by file
+	symbols map[lsp.URI][]symbol
+	// renames are symbols to unexport
+	renames []symbol
+}
+
+// add adds symbol from diagnostic to fix, returning false if diagnostic can't be fixed.
+func (f *fixer) add(diag diagnostic) bool {
+	switch diag.Code {
+	case CodeUnused:
+		f.symbols[diag.Symbol.URI] = append(f.symbols[diag.Symbol.URI], diag.Symbol)
+		return true
+	case CodeUnexportable:
+		if _, ok := unexportedName(simpleName(diag.Symbol)); !ok {
+			return false
+		}
+		f.renames = append(f.renames, diag.Symbol)
+		return true
+	default:
+		return false
+	}
+}
+
+// apply fixes collected symbols. If dryRun is set, files are not changed,
+// instead unified diff is written to w. Diagnostics for symbols which can't be fixed are returned.
+func (f *fixer) apply(dryRun bool, w io.Writer) ([]diagnostic, error) {
+	// original and fixed contents of files
+	original, fixed := map[lsp.URI][]byte{}, map[lsp.URI][]byte{}
+	load := func(uri lsp.URI) ([]byte, error) {
+		if src, ok := fixed[uri]; ok {
+			return src, nil
+		}
+
+		src, err := os.ReadFile(strings.TrimPrefix(string(uri), "file://"))
+		if err != nil {
+			return nil, errors.Wrap(err, "read file to fix")
+		}
+		original[uri], fixed[uri] = src, src
+		return src, nil
+	}
+
+	var skipped []diagnostic
+	// Positions of symbols following renamed names on the same line are shifted after every rename.
+	// Changes are not saved to disk until the end, so gopls is notified about them to make next renames correct.
+	for i, s := range f.renames {
+		newName, _ := unexportedName(simpleName(s))
+		edit, err := f.client.Rename(lsp.Location{URI: s.URI, Range: s.SelectionRange}, newName)
+		if err != nil {
+			slog.Warn("can't rename", "symbol", s.QualifiedName(), "name", newName, "err", err)
+			skipped = append(skipped, diagnostic{Symbol: s, Code: CodeUnexportable})
+			continue
+		}
+
+		edits := edit.Edits()
+		for _, uri := range slices.Sorted(maps.Keys(edits)) {
+			src, err := load(uri)
+			if err != nil {
+				return nil, err
+			}
+
+			fixed[uri] = applyEdits(src, edits[uri])
+			if err := f.client.DidChange(uri, string(fixed[uri])); err != nil {
+				return nil, errors.Wrap(err, "notify about renamed symbol")
+			}
+
+			for j := range f.renames[i+1:] {
+				if next := &f.renames[i+1+j]; next.URI == uri {
+					next.documentSymbol = shiftSymbol(next.documentSymbol, edits[uri])
+				}
+			}
+			for j, removed := range f.symbols[uri] {
+				f.symbols[uri][j].documentSymbol = shiftSymbol(removed.documentSymbol, edits[uri])
+			}
+		}
+	}
+
+	for _, uri := range slices.Sorted(maps.Keys(f.symbols)) {
+		src, err := load(uri)
+		if err != nil {
+			return nil, err
+		}
+
+		filename := strings.TrimPrefix(string(uri), "file://")
+		res, skippedInFile, err := removeSymbols(filename, src, f.symbols[uri])
+		if err != nil {
+			return nil, err
+		}
+		fixed[uri] = res
+		for _, s := range skippedInFile {
+			skipped = append(skipped, diagnostic{Symbol: s, Code: CodeUnused})
+		}
+	}
+
+	for _, uri := range slices.Sorted(maps.Keys(fixed)) {
+		if bytes.Equal(original[uri], fixed[uri]) {
+			continue
+		}
+
+		filename := strings.TrimPrefix(string(uri), "file://")
+		if !dryRun {
+			if err := os.WriteFile(filename, fixed[uri], 0o644); err != nil {
+				return nil, errors.Wrap(err, "write fixed file")
+			}
+			slog.Info("fixed", "file", filename)
+			continue
+		}
+
+		path, err := relPath(f.workspaceDir, uri)
+		if err != nil {
+			return nil, err
+		}
+		if err := difflib.WriteUnifiedDiff(w, difflib.UnifiedDiff{
+			A:        difflib.SplitLines(string(original[uri])),
+			B:        difflib.SplitLines(string(fixed[uri])),
+			FromFile: "a/" + path,
+			ToFile:   "b/" + path,
+			Context:  3,
+		}); err != nil {
+			return nil, errors.Wrap(err, "write diff")
+		}
+	}
+	return skipped, nil
+}
@@ -0,0 +1,201 @@
+package engine
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/rprtr258/punused/internal/lsp"
+)
+
+func TestRemoveSymbols(t *testing.T) {
+	const src = `package p
+
+import (
+	"fmt"
+	"strings"
+)
+
+var (
+	UsedVar = 1
+	// UnusedVar is documented.
+	UnusedVar = 2
+)
+
+const (
+	UnusedConst1 = 1
+	UnusedConst2 = 2
+)
+
+// UnusedFunction uses strings.
+func UnusedFunction() {
+	fmt.Println(strings.ToUpper("x"))
+}
+
+var A, B = 1, 2
+
+var (
+	UninitializedUsed   int
+	UninitializedUnused string
+)
+
+type T struct {
+	Used   int
+	Unused int // trailing comment
+}
+
+type UnusedType struct {
+	UnusedField int
+}
+
+type Mode int
+
+// removing any constant renumbers or breaks the others
+const (
+	ModeUnused Mode = iota
+	ModeA
+	ModeB
+)
+
+func Used() { fmt.Println() }
+`
+
+	// symbolAt returns symbol with selection range at first occurrence of name outside of comments
+	symbolAt := func(name string) symbol {
+		re := regexp.MustCompile(`\b` + name + `\b`)
+		for i, line := range strings.Split(src, "\n") {
+			if strings.HasPrefix(strings.TrimSpace(line), "//") {
+				continue
+			}
+			if loc := re.FindStringIndex(line); loc != nil {
+				pos := lsp.Position{Line: i, Character: loc[0]}
+				return symbol{documentSymbol: documentSymbol{
+					Name:           name,
+					SelectionRange: lsp.Range{Start: pos, End: pos},
+				}}
+			}
+		}
+		t.Fatalf("symbol %s not found", name)
+		return symbol{}
+	}
+
+	got, skipped, err := removeSymbols("p.go", []byte(src), []symbol{
+		symbolAt("UnusedVar"),
+		symbolAt("UnusedConst1"),
+		symbolAt("UnusedConst2"),
+		symbolAt("UnusedFunction"),
+		symbolAt("A"),
+		symbolAt("UninitializedUnused"),
+		symbolAt("Unused"),
+		symbolAt("UnusedType"),
+		symbolAt("UnusedField"),
+		symbolAt("ModeUnused"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const want = `package p
+
+import (
+	"fmt"
+)
+
+var (
+	UsedVar = 1
+)
+
+var A, B = 1, 2
+
+var (
+	UninitializedUsed int
+)
+
+type T struct {
+	Used int
+}
+
+type Mode int
+
+// removing any constant renumbers or breaks the others
+const (
+	ModeUnused Mode = iota
+	ModeA
+	ModeB
+)
+
+func Used() { fmt.Println() }
+`
+	if diff := cmp.Diff(want, string(got)); diff != "" {
+		t.Fatal("unexpected output\n+ actual\n- expected\n" + diff)
+	}
+
+	if len(skipped) != 2 || skipped[0].Name != "A" || skipped[1].Name != "ModeUnused" {
+		t.Fatalf("expected A and ModeUnused to be skipped, got %v", skipped)
+	}
+}
+
+// fakeRenamer renames only symbol itself, replacing its name with newName.
+type fakeRenamer struct{}
+
+func (fakeRenamer) Rename(loc lsp.Location, newName string) (lsp.WorkspaceEdit, error) {
+	return lsp.WorkspaceEdit{Changes: map[lsp.URI][]lsp.TextEdit{loc.URI: {{Range: loc.Range, NewText: newName}}}}, nil
+}
+
+func (fakeRenamer) DidChange(lsp.URI, string) error {
+	return nil
+}
+
+func TestFixRenameShiftsPositions(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "p.go")
+	const src = "package p\n\nvar ΩΩ, Ω = 1, 2; var Unused = 3\n"
+	if err := os.WriteFile(filename, []byte(src), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	uri := documentURI("", filename)
+	at := func(name string, char int) symbol {
+		rng := lsp.Range{Start: lsp.Position{Line: 2, Character: char}, End: lsp.Position{Line: 2, Character: char + utf16Len([]byte(name))}}
+		return symbol{documentSymbol: documentSymbol{Name: name, Range: rng, SelectionRange: rng}, URI: uri}
+	}
+	// Ω takes two bytes more than ω, so byte offsets after renamed names change
+	fix := &fixer{"", fakeRenamer{}, map[lsp.URI][]symbol{uri: {at("Unused", 22)}}, []symbol{at("ΩΩ", 4), at("Ω", 8)}}
+	skipped, err := fix.apply(false, nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if len(skipped) != 0 {
+		t.Fatalf("expected all symbols to be fixed, got %v", skipped)
+	}
+
+	got, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff("package p\n\nvar ωω, ω = 1, 2\n", string(got)); diff != "" {
+		t.Error("unexpected output\n+ actual\n- expected\n" + diff)
+	}
+}
+
+func TestShift(t *testing.T) {
+	edits := []lsp.TextEdit{
+		{Range: lsp.Range{Start: lsp.Position{Line: 1, Character: 2}, End: lsp.Position{Line: 1, Character: 4}}, NewText: "abc"},
+		{Range: lsp.Range{Start: lsp.Position{Line: 1, Character: 6}, End: lsp.Position{Line: 1, Character: 9}}, NewText: "𝔸"},
+	}
+	for _, test := range []struct {
+		pos, want lsp.Position
+	}{
+		{lsp.Position{Line: 0, Character: 5}, lsp.Position{Line: 0, Character: 5}},
+		{lsp.Position{Line: 1, Character: 2}, lsp.Position{Line: 1, Character: 2}},
+		{lsp.Position{Line: 1, Character: 4}, lsp.Position{Line: 1, Character: 5}},
+		{lsp.Position{Line: 1, Character: 10}, lsp.Position{Line: 1, Character: 10}},
+	} {
+		if got := shift(test.pos, edits); got != test.want {
+			t.Errorf("%+v: expected %+v, got %+v", test.pos, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,651 @@
+package engine
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/rprtr258/punused/internal/lsp"
+)
+
+var requestID uint64 = 5000
+
+// newClient starts gopls in workspace directory, concurrency is max number of requests in flight.
+func newClient(ctx context.Context, workspaceDir string, concurrency int) (*goplsClient, error) {
+	args := []string{
+		"serve",
+		// "-rpc.trace",
+		// "-logfile=gopls.log",
+	}
+	cmd := exec.Command("gopls", args...)
+	cmd.Stderr = os.Stderr
+	cmd.Dir = workspaceDir
+	conn, err := newConn(cmd)
+	if err != nil {
+		return nil, errors.Wrap(err, "newConn")
+	}
+
+	if err := conn.Start(); err != nil {
+		return nil, errors.Wrap(err, "start")
+	}
+
+	client := &goplsClient{
+		ctx:          ctx,
+		workspaceDir: filepath.Clean(filepath.ToSlash(workspaceDir)),
+		writeMu:      sync.Mutex{},
+		conn:         conn,
+		sem:          make(chan struct{}, max(concurrency, 1)),
+		pendingMu:    sync.Mutex{},
+		pending:      map[uint64]chan lsp.Response{},
+		readDone:     make(chan struct{}),
+		readErr:      nil,
+		handlers:     defaultHandlers(),
+		progress:     map[string]string{},
+		started:      make(chan struct{}),
+		loaded:       make(chan struct{}),
+		versionsMu:   sync.Mutex{},
+		versions:     map[lsp.URI]int{},
+	}
+	client.handlers["$/progress"] = client.handleProgress
+	go client.read()
+
+	initParams := &lsp.InitializeParams{
+		RootURI: client.documentURI(""),
+		Capabilities: lsp.ClientCapabilities{
+			TextDocument: lsp.TextDocumentClientCapabilities{
+				DocumentSymbol: struct {
+					// SymbolKind struct {
+					// 	ValueSet []int `json:"valueSet,omitempty"`
+					// } `json:"symbolKind"`
+					HierarchicalDocumentSymbolSupport bool `json:"hierarchicalDocumentSymbolSupport,omitempty"`
+				}{
+					HierarchicalDocumentSymbolSupport: true,
+				},
+			},
+			Window: lsp.WindowClientCapabilities{WorkDoneProgress: true},
+		},
+		WorkspaceFolders: []lsp.WorkspaceFolder{
+			{
+				URI: client.documentURI(""),
+			},
+		},
+	}
+
+	if err := client.Initialize(initParams); err != nil {
+		return nil, errors.Wrap(err, "initialize")
+	}
+
+	if err := client.Initialized(); err != nil {
+		return nil, errors.Wrap(err, "initialized")
+	}
+
+	// references are incomplete until all packages are loaded
+	if err := client.waitLoaded(_progressWait); err != nil {
+		return nil, errors.Wrap(err, "wait for workspace load")
+	}
+	return client, nil
+}
+
+// _progressWait is how long gopls is waited for to report work done progress of workspace load.
+const _progressWait = 5 * time.Second
+
+// waitLoaded waits until gopls finishes workspace load, which is reported as work done progress.
+// If gopls reports no progress in progressWait, it waits for response to workspace symbol query
+// instead, since queries are answered only once workspace is loaded.
+func (c *goplsClient) waitLoaded(progressWait time.Duration) error {
+	select {
+	case <-c.started:
+	case <-time.After(progressWait):
+		slog.Debug("gopls reported no progress, waiting for workspace symbols")
+		return c.Call("workspace/symbol", lsp.WorkspaceSymbolParams{Query: ""}, nil)
+	case <-c.readDone:
+		return c.readErr
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+
+	select {
+	case <-c.loaded:
+		return nil
+	case <-c.readDone:
+		return c.readErr
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+}
+
+func newConn(cmd *exec.Cmd) (_ goplsConn, err error) {
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return goplsConn{}, err
+	}
+	defer func() {
+		if err != nil {
+			_ = in.Close()
+		}
+	}()
+
+	out, err := cmd.StdoutPipe()
+	return goplsConn{out, in, cmd}, err
+}
+
+type goplsConn struct {
+	io.ReadCloser
+	io.WriteCloser
+	cmd *exec.Cmd
+}
+
+// Close closes conn's WriteCloser ReadClosers.
+func (c goplsConn) Close() error {
+	writeErr := c.WriteCloser.Close()
+	readErr := c.ReadCloser.Close()
+
+	if writeErr != nil && writeErr != os.ErrClosed {
+		return writeErr
+	}
+
+	if readErr != nil && readErr != os.ErrClosed {
+		return readErr
+	}
+
+	return nil
+}
+
+// Start starts conn's Cmd.
+func (c goplsConn) Start() error {
+	err := c.cmd.Start()
+	if err != nil {
+		return errors.Wrapf(err, "close: %v", c.Close())
+	}
+	return errors.Wrap(err, "start")
+}
+
+type goplsClient struct {
+	ctx          context.Context
+	workspaceDir string
+
+	writeMu sync.Mutex
+	conn    goplsConn
+	// sem bounds number of requests in flight
+	sem chan struct{}
+
+	pendingMu sync.Mutex
+	// pending are channels awaiting responses by request ID
+	pending map[uint64]chan lsp.Response
+	// readDone is closed once reader stops, readErr is set before that
+	readDone chan struct{}
+	readErr  error
+	// handlers of requests and notifications from gopls by method,
+	// must not be changed after reader is started
+	handlers map[string]handler
+	// progress are titles of work in progress reported by gopls by token, accessed by reader only
+	progress map[string]string
+	// started is closed once gopls reports first work done progress
+	started chan struct{}
+	// loaded is closed once gopls finishes initial workspace load, that is all work in progress
+	loaded chan struct{}
+
+	versionsMu sync.Mutex
+	// versions of documents opened in gopls
+	versions map[lsp.URI]int
+}
+
+// handler handles request or notification from the other side of connection, returning result of request.
+// Result of notification is ignored.
+type handler func(params json.RawMessage) (any, error)
+
+// read reads messages from gopls until connection is closed, sending
+// responses to calls awaiting them and handling requests and notifications.
+// It is the only reader of connection.
+func (c *goplsClient) read() {
+	defer close(c.readDone)
+
+	r := bufio.NewReader(c.conn)
+	for {
+		b, err := readMessage(r)
+		if err != nil {
+			c.readErr = err
+			return
+		}
+
+		var msg lsp.Message
+		if err := json.Unmarshal(b, &msg); err != nil {
+			c.readErr = errors.Wrap(err, "unmarshal")
+			return
+		}
+
+		switch {
+		case msg.IsResponse():
+			c.pendingMu.Lock()
+			respChan, ok := c.pending[msg.ID.Num]
+			delete(c.pending, msg.ID.Num)
+			c.pendingMu.Unlock()
+			if ok {
+				respChan <- msg.Response()
+			}
+		case msg.IsRequest():
+			if err := c.Write(c.handle(msg)); err != nil {
+				c.readErr = errors.Wrapf(err, "respond to %s", msg.Method)
+				return
+			}
+		case msg.IsNotification():
+			if h, ok := c.handlers[msg.Method]; ok {
+				if _, err := h(msg.Params); err != nil {
+					slog.Warn("handle gopls notification", "method", msg.Method, "err", err)
+				}
+			} else {
+				slog.Debug("unhandled gopls notification", "method", msg.Method)
+			}
+		}
+	}
+}
+
+// handleProgress tracks work done progress reported by gopls, closing loaded once
+// initial workspace load is finished, that is once no work is in progress. Titles of
+// progress are not relied on, since they may differ between gopls versions.
+func (c *goplsClient) handleProgress(params json.RawMessage) (any, error) {
+	var progress lsp.ProgressParams
+	if err := json.Unmarshal(params, &progress); err != nil {
+		return nil, err
+	}
+	var value lsp.WorkDoneProgress
+	if err := json.Unmarshal(progress.Value, &value); err != nil {
+		return nil, err
+	}
+
+	token := string(progress.Token)
+	switch value.Kind {
+	case "begin":
+		c.progress[token] = value.Title
+		if !isClosed(c.started) {
+			close(c.started)
+		}
+		slog.Debug("gopls progress started", "title", value.Title, "message", value.Message)
+	case "report":
+		slog.Debug("gopls progress", "title", c.progress[token], "message", value.Message)
+	case "end":
+		title := c.progress[token]
+		delete(c.progress, token)
+		slog.Debug("gopls progress finished", "title", title, "message", value.Message)
+		if len(c.progress) == 0 && !isClosed(c.loaded) {
+			close(c.loaded)
+		}
+	}
+	return nil, nil
+}
+
+func isClosed(ch chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// handle handles request from gopls, returning response to it.
+func (c *goplsClient) handle(req lsp.Message) lsp.Response {
+	return respond(c.handlers, req)
+}
+
+// respond handles request with handler of its method, returning response to it.
+func respond(handlers map[string]handler, req lsp.Message) lsp.Response {
+	resp := lsp.Response{RPCVersion: "2.0", ID: *req.ID, Result: nil, Error: nil}
+
+	h, ok := handlers[req.Method]
+	if !ok {
+		resp.Error = &lsp.ResponseError{Code: lsp.MethodNotFound, Message: "method not found: " + req.Method, Data: nil}
+		return resp
+	}
+
+	result, err := h(req.Params)
+	if err != nil {
+		resp.Error = &lsp.ResponseError{Code: lsp.InternalError, Message: err.Error(), Data: nil}
+		return resp
+	}
+
+	if resp.Result, err = json.Marshal(result); err != nil {
+		resp.Error = &lsp.ResponseError{Code: lsp.InternalError, Message: "marshal result: " + err.Error(), Data: nil}
+	}
+	return resp
+}
+
+// defaultHandlers respond to requests gopls may send and log its messages.
+func defaultHandlers() map[string]handler {
+	// empty results are valid responses to requests
+	null := func(json.RawMessage) (any, error) { return nil, nil }
+	logMessage := func(params json.RawMessage) (any, error) {
+		var msg lsp.LogMessageParams
+		if err := json.Unmarshal(params, &msg); err != nil {
+			return nil, err
+		}
+
+		level := slog.LevelDebug
+		switch msg.Type {
+		case lsp.MTError:
+			level = slog.LevelError
+		case lsp.MTWarning:
+			level = slog.LevelWarn
+		}
+		slog.Log(context.Background(), level, "gopls: "+msg.Message)
+		return nil, nil
+	}
+	return map[string]handler{
+		"window/logMessage":               logMessage,
+		"window/showMessage":              logMessage,
+		"$/progress":                      null,
+		"textDocument/publishDiagnostics": null,
+		"workspace/configuration": func(params json.RawMessage) (any, error) {
+			var config lsp.ConfigurationParams
+			if err := json.Unmarshal(params, &config); err != nil {
+				return nil, err
+			}
+			// null is default configuration of every requested item
+			return make(lsp.ConfigurationResult, len(config.Items)), nil
+		},
+		"window/workDoneProgress/create": null,
+		"client/registerCapability":      null,
+		"client/unregisterCapability":    null,
+	}
+}
+
+// readMessage reads single message body using the format specified by:
+// https://microsoft.github.io/language-server-protocol/specifications/base/0.9/specification/#headerPart
+func readMessage(r *bufio.Reader) ([]byte, error) {
+	contentLength := -1
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return nil, errors.Wrap(err, "read header")
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if line == "" {
+			break
+		}
+
+		name, value, ok := strings.Cut(line, ":")
+		if ok && strings.EqualFold(name, "Content-Length") {
+			if contentLength, err = strconv.Atoi(strings.TrimSpace(value)); err != nil {
+				return nil, errors.Wrap(err, "parse Content-Length")
+			}
+		}
+	}
+	if contentLength < 0 {
+		return nil, errors.New("missing Content-Length header")
+	}
+
+	msg := make([]byte, contentLength)
+	if _, err := io.ReadFull(r, msg); err != nil {
+		return nil, errors.Wrap(err, "read content")
+	}
+	return msg, nil
+}
+
+// Call calls the gopls method with the params given. If result is non-nil, the response body is unmarshalled into it.
+// Call is safe for concurrent use, number of requests in flight is bounded by client concurrency.
+func (c *goplsClient) Call(method string, params, result any) error {
+	select {
+	case c.sem <- struct{}{}:
+		defer func() { <-c.sem }()
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+
+	id := atomic.AddUint64(&requestID, 1)
+	defer func(start time.Time) {
+		slog.Debug("gopls request", "method", method, "id", id, "duration", time.Since(start))
+	}(time.Now())
+
+	respChan := make(chan lsp.Response, 1)
+	c.pendingMu.Lock()
+	c.pending[id] = respChan
+	c.pendingMu.Unlock()
+	defer func() {
+		c.pendingMu.Lock()
+		delete(c.pending, id)
+		c.pendingMu.Unlock()
+	}()
+
+	if err := c.Write(lsp.Request{
+		RPCVersion: "2.0",
+		ID: lsp.ID{
+			Num: id,
+		},
+		Method: method,
+		Params: params,
+	}); err != nil {
+		return errors.Wrap(err, "write")
+	}
+
+	select {
+	case resp := <-respChan:
+		if resp.Error != nil {
+			return errors.Wrap(resp.Error, method)
+		}
+		if result != nil && resp.Result != nil {
+			return errors.Wrap(json.Unmarshal(resp.Result, result), "unmarshal")
+		}
+		return nil
+	case <-c.readDone:
+		return errors.Wrap(c.readErr, "read")
+	case <-c.ctx.Done():
+		return c.ctx.Err()
+	}
+}
+
+func (c *goplsClient) Close() error {
+	return c.conn.Close()
+}
+
+func (c *goplsClient) DocumentReferences(loc lsp.Location) ([]lsp.Location, error) {
+	params := &lsp.ReferencesParams{
+		Context: lsp.ReferenceContext{
+			IncludeDeclaration: false,
+		},
+		TextDocumentPositionParams: lsp.TextDocumentPositionParams{
+			TextDocument: lsp.TextDocumentIdentifier{
+				URI: loc.URI,
+			},
+			Position: loc.Range.Start,
+		},
+	}
+
+	var result []lsp.Location
+	if err := c.Call("textDocument/references", params, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// Implementation returns locations of implementations of interface or interface method at loc,
+// or of interfaces and interface methods implemented by type or method at loc.
+func (c *goplsClient) Implementation(loc lsp.Location) ([]lsp.Location, error) {
+	params := &lsp.TextDocumentPositionParams{
+		TextDocument: lsp.TextDocumentIdentifier{
+			URI: loc.URI,
+		},
+		Position: loc.Range.Start,
+	}
+
+	var result []lsp.Location
+	if err := c.Call("textDocument/implementation", params, &result); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+func (c *goplsClient) DocumentSymbol(filename string) ([]documentSymbol, error) {
+	uri := c.documentURI(filename)
+	params := &lsp.DocumentSymbolParams{
+		TextDocument: lsp.TextDocumentIdentifier{
+			URI: uri,
+		},
+	}
+
+	var result []documentSymbol
+	if err := c.Call("textDocument/documentSymbol", params, &result); err != nil {
+		return nil, err
+	}
+	if err := c.Open(filename); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// Open opens document in gopls unless it is already open. Packages which are not
+// part of workspace, e.g. in testdata directories, are loaded only once their files are open.
+func (c *goplsClient) Open(filename string) error {
+	uri := c.documentURI(filename)
+	c.versionsMu.Lock()
+	_, ok := c.versions[uri]
+	c.versionsMu.Unlock()
+	if ok {
+		return nil
+	}
+
+	b, err := os.ReadFile(strings.TrimPrefix(string(uri), "file://"))
+	if err != nil {
+		return err
+	}
+	return c.didOpen(uri, string(b))
+}
+
+func (c *goplsClient) didOpen(uri lsp.URI, text string) error {
+	if err := c.Notify("textDocument/didOpen", lsp.DidOpenTextDocumentParams{
+		TextDocument: lsp.TextDocumentItem{
+			URI:        uri,
+			LanguageID: "go",
+			Version:    1,
+			Text:       text,
+		},
+	}); err != nil {
+		return err
+	}
+
+	c.versionsMu.Lock()
+	defer c.versionsMu.Unlock()
+	c.versions[uri] = 1
+	return nil
+}
+
+// DidChange notifies gopls about new content of document, which is not saved on disk.
+func (c *goplsClient) DidChange(uri lsp.URI, text string) error {
+	c.versionsMu.Lock()
+	version, ok := c.versions[uri]
+	c.versionsMu.Unlock()
+	if !ok {
+		return c.didOpen(uri, text)
+	}
+
+	version++
+	if err := c.Notify("textDocument/didChange", lsp.DidChangeTextDocumentParams{
+		TextDocument: lsp.VersionedTextDocumentIdentifier{
+			TextDocumentIdentifier: lsp.TextDocumentIdentifier{URI: uri},
+			Version:                version,
+		},
+		ContentChanges: []lsp.TextDocumentContentChangeEvent{{Text: text}},
+	}); err != nil {
+		return err
+	}
+
+	c.versionsMu.Lock()
+	defer c.versionsMu.Unlock()
+	c.versions[uri] = version
+	return nil
+}
+
+// Rename returns edits renaming symbol at loc to newName in whole workspace.
+func (c *goplsClient) Rename(loc lsp.Location, newName string) (lsp.WorkspaceEdit, error) {
+	params := &lsp.RenameParams{
+		TextDocument: lsp.TextDocumentIdentifier{
+			URI: loc.URI,
+		},
+		Position: loc.Range.Start,
+		NewName:  newName,
+	}
+
+	var result lsp.WorkspaceEdit
+	if err := c.Call("textDocument/rename", params, &result); err != nil {
+		return lsp.WorkspaceEdit{}, err
+	}
+	return result, nil
+}
+
+// Notify sends notification to gopls, which is not responded to.
+func (c *goplsClient) Notify(method string, params any) error {
+	return errors.Wrap(c.Write(lsp.Notification{
+		RPCVersion: "2.0",
+		Method:     method,
+		Params:     params,
+	}), "write")
+}
+
+// Write writes a request, notification or response to gopls.
+func (c *goplsClient) Write(msg any) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return writeMessage(c.conn, msg)
+}
+
+// writeMessage writes a request, notification or response using the format specified by:
+// https://github.com/Microsoft/language-server-protocol/blob/gh-pages/_specifications/specification-3-14.md#text-documents
+func writeMessage(w io.Writer, msg any) error {
+	b, err := json.Marshal(msg)
+	if err != nil {
+		return errors.Wrap(err, "marshal")
+	}
+
+	if _, err = fmt.Fprintf(w, "Content-Length: %d\r\n\r\n", len(b)); err != nil {
+		return errors.Wrap(err, "write content-length")
+	}
+
+	_, err = w.Write(b)
+	return errors.Wrap(err, "write")
+}
+
+// Initialize initializes gopls, capabilities of gopls are not checked.
+func (c *goplsClient) Initialize(params *lsp.InitializeParams) error {
+	return errors.Wrap(c.Call("initialize", params, nil), "initialize")
+}
+
+func (c *goplsClient) Initialized() error {
+	return c.Notify("initialized", &lsp.InitializedParams{})
+}
+
+type symbol struct {
+	documentSymbol
+	URI lsp.URI
+	// Parent is qualified name of enclosing symbol, empty for top level symbols.
+	Parent string
+}
+
+// QualifiedName returns name of symbol prefixed with its enclosing symbols,
+// in the same form gopls names methods, e.g. (MyType).MyField.
+func (s symbol) QualifiedName() string {
+	switch {
+	case s.Parent == "":
+		return s.Name
+	case strings.HasPrefix(s.Parent, "("):
+		return s.Parent + "." + s.Name
+	default:
+		return "(" + s.Parent + ")." + s.Name
+	}
+}
+
+func (c *goplsClient) documentURI(filename string) lsp.URI {
+	return documentURI(c.workspaceDir, filename)
+}
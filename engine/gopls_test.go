@@ -0,0 +1,134 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rprtr258/punused/internal/lsp"
+)
+
+func TestReadMessage(t *testing.T) {
+	r := bufio.NewReader(strings.NewReader("Content-Length: 2\r\n\r\n{}" +
+		"Content-Type: application/vscode-jsonrpc; charset=utf-8\r\ncontent-length:4\r\n\r\nnull" +
+		"Content-Type: x\r\n\r\n"))
+	for _, want := range []string{"{}", "null"} {
+		msg, err := readMessage(r)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if string(msg) != want {
+			t.Errorf("expected message %q, got %q", want, msg)
+		}
+	}
+
+	if _, err := readMessage(r); err == nil || !strings.Contains(err.Error(), "missing Content-Length") {
+		t.Errorf("expected missing Content-Length error, got %v", err)
+	}
+}
+
+func TestHandle(t *testing.T) {
+	c := &goplsClient{handlers: defaultHandlers()}
+	for _, test := range []struct {
+		msg  string
+		want string
+	}{
+		{
+			`{"jsonrpc":"2.0","id":1,"method":"workspace/configuration","params":{"items":[{"section":"gopls"},{}]}}`,
+			`{"jsonrpc":"2.0","id":1,"result":[null,null]}`,
+		},
+		{
+			`{"jsonrpc":"2.0","id":"a","method":"window/workDoneProgress/create","params":{"token":"t"}}`,
+			`{"jsonrpc":"2.0","id":"a","result":null}`,
+		},
+		{
+			`{"jsonrpc":"2.0","id":2,"method":"workspace/unknown","params":{}}`,
+			`{"jsonrpc":"2.0","id":2,"error":{"code":-32601,"message":"method not found: workspace/unknown","data":null}}`,
+		},
+	} {
+		var msg lsp.Message
+		if err := json.Unmarshal([]byte(test.msg), &msg); err != nil {
+			t.Fatal(err.Error())
+		}
+		if !msg.IsRequest() || msg.IsNotification() || msg.IsResponse() {
+			t.Errorf("%s: expected request", test.msg)
+		}
+
+		got, err := json.Marshal(c.handle(msg))
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if string(got) != test.want {
+			t.Errorf("expected response %s, got %s", test.want, got)
+		}
+	}
+}
+
+func TestHandleProgress(t *testing.T) {
+	c := &goplsClient{progress: map[string]string{}, started: make(chan struct{}), loaded: make(chan struct{})}
+	for _, params := range []string{
+		`{"token":"1","value":{"kind":"begin","title":"Loading workspace","message":"Loading packages..."}}`,
+		`{"token":2,"value":{"kind":"begin","title":"Indexing"}}`,
+		`{"token":2,"value":{"kind":"end"}}`,
+	} {
+		if _, err := c.handleProgress(json.RawMessage(params)); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if !isClosed(c.started) || isClosed(c.loaded) {
+		t.Fatal("workspace must not be loaded before all progress ends")
+	}
+
+	for range 2 {
+		if _, err := c.handleProgress(json.RawMessage(`{"token":"1","value":{"kind":"end","message":"Finished loading packages."}}`)); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	if !isClosed(c.loaded) {
+		t.Error("expected workspace to be loaded")
+	}
+}
+
+// TestWaitLoadedWithoutProgress checks that workspace is considered loaded
+// once workspace symbols are returned, if gopls reports no progress.
+func TestWaitLoadedWithoutProgress(t *testing.T) {
+	clientR, serverW := io.Pipe()
+	serverR, clientW := io.Pipe()
+	c := &goplsClient{
+		ctx:      t.Context(),
+		conn:     goplsConn{clientR, clientW, nil},
+		sem:      make(chan struct{}, 1),
+		pending:  map[uint64]chan lsp.Response{},
+		readDone: make(chan struct{}),
+		handlers: defaultHandlers(),
+		progress: map[string]string{},
+		started:  make(chan struct{}),
+		loaded:   make(chan struct{}),
+	}
+	go c.read()
+	defer c.conn.Close()
+
+	methods := make(chan string, 1)
+	go func() {
+		b, err := readMessage(bufio.NewReader(serverR))
+		if err != nil {
+			return
+		}
+		var msg lsp.Message
+		_ = json.Unmarshal(b, &msg)
+		methods <- msg.Method
+		resp := fmt.Sprintf(`{"jsonrpc":"2.0","id":%d,"result":[]}`, msg.ID.Num)
+		_, _ = fmt.Fprintf(serverW, "Content-Length: %d\r\n\r\n%s", len(resp), resp)
+	}()
+
+	if err := c.waitLoaded(10 * time.Millisecond); err != nil {
+		t.Fatal(err.Error())
+	}
+	if method := <-methods; method != "workspace/symbol" {
+		t.Errorf("expected workspace/symbol request, got %s", method)
+	}
+}
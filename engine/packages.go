@@ -0,0 +1,658 @@
+package engine
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"log/slog"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/packages"
+	"golang.org/x/tools/go/types/objectpath"
+
+	"github.com/rprtr258/punused/internal/lsp"
+)
+
+// objectKey identifies object regardless of whether it is type checked from
+// source or imported from export data, e.g. by different test variants of package.
+type objectKey string
+
+// docPosition is a position in document.
+type docPosition struct {
+	URI lsp.URI
+	Pos lsp.Position
+}
+
+// packagesBackend type checks packages of opened files in process, using
+// go/types Defs and Uses maps to find references.
+type packagesBackend struct {
+	ctx          context.Context
+	workspaceDir string
+	// files are absolute paths of opened files
+	files []string
+
+	loadOnce sync.Once
+	loadErr  error
+	fset     *token.FileSet
+	// syntax of loaded files by absolute path
+	syntax map[string]*ast.File
+	// src of files by absolute path, to convert positions to UTF-16 based ones
+	src map[string][]byte
+	// goroot is GOROOT of go command, export data names standard library files relative to it
+	goroot string
+	// defs are objects declared by identifiers at position
+	defs map[docPosition]types.Object
+	// decls are declarations of objects
+	decls map[objectKey]lsp.Location
+	// uses are references to objects, sorted
+	uses map[objectKey][]lsp.Location
+	// methods are named types having method with given name, to find implementations
+	methods map[string][]*types.Named
+	// named are keys of types added to methods
+	named map[objectKey]bool
+	// imported are paths of imported packages, which types are added to methods
+	imported map[string]bool
+
+	// mu guards src and external, which are updated by concurrent queries
+	mu sync.Mutex
+	// external are objects declared outside of loaded files, e.g. interfaces
+	// of standard library, found as implementations
+	external map[docPosition]types.Object
+}
+
+func newPackagesBackend(ctx context.Context, workspaceDir string) *packagesBackend {
+	return &packagesBackend{
+		ctx:          ctx,
+		workspaceDir: workspaceDir,
+		files:        nil,
+		loadOnce:     sync.Once{},
+		loadErr:      nil,
+		fset:         token.NewFileSet(),
+		syntax:       map[string]*ast.File{},
+		src:          map[string][]byte{},
+		goroot:       "",
+		defs:         map[docPosition]types.Object{},
+		decls:        map[objectKey]lsp.Location{},
+		uses:         map[objectKey][]lsp.Location{},
+		methods:      map[string][]*types.Named{},
+		named:        map[objectKey]bool{},
+		imported:     map[string]bool{},
+		mu:           sync.Mutex{},
+		external:     map[docPosition]types.Object{},
+	}
+}
+
+func (b *packagesBackend) Open(filename string) error {
+	b.files = append(b.files, strings.TrimPrefix(string(documentURI(b.workspaceDir, filename)), "file://"))
+	return nil
+}
+
+func (b *packagesBackend) Close() error {
+	return nil
+}
+
+// ModuleRoot returns directory of go.mod file dir belongs to.
+func ModuleRoot(dir string) (string, error) {
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(filepath.Join(d, "go.mod")); err == nil {
+			return d, nil
+		} else if !errors.Is(err, os.ErrNotExist) {
+			return "", err
+		}
+
+		if filepath.Dir(d) == d {
+			return "", errors.Errorf("%s is not in Go module", dir)
+		}
+	}
+}
+
+// load type checks all packages of modules opened files belong to, once.
+// Directories of opened files are loaded explicitly, since ./... pattern
+// skips testdata directories.
+func (b *packagesBackend) load() error {
+	b.loadOnce.Do(func() {
+		goroot, err := exec.CommandContext(b.ctx, "go", "env", "GOROOT").Output()
+		if err != nil {
+			b.loadErr = errors.Wrap(err, "get GOROOT")
+			return
+		}
+		b.goroot = strings.TrimSpace(string(goroot))
+
+		modules := map[string][]string{}
+		for _, filename := range b.files {
+			dir := filepath.Dir(filename)
+			root, err := ModuleRoot(dir)
+			if err != nil {
+				b.loadErr = err
+				return
+			}
+
+			rel, err := filepath.Rel(root, dir)
+			if err != nil {
+				b.loadErr = errors.Wrap(err, "get package directory")
+				return
+			}
+			pattern := "./" + filepath.ToSlash(rel)
+			if !slices.Contains(modules[root], pattern) {
+				modules[root] = append(modules[root], pattern)
+			}
+		}
+
+		for _, root := range slices.Sorted(maps.Keys(modules)) {
+			if b.loadErr = b.loadModule(root, append([]string{"./..."}, modules[root]...)); b.loadErr != nil {
+				return
+			}
+		}
+
+		for k, refs := range b.uses {
+			slices.SortFunc(refs, compareLocations)
+			b.uses[k] = slices.Compact(refs)
+		}
+	})
+	return b.loadErr
+}
+
+func (b *packagesBackend) loadModule(root string, patterns []string) error {
+	pkgs, err := packages.Load(&packages.Config{
+		Mode: packages.NeedName | packages.NeedFiles | packages.NeedCompiledGoFiles |
+			packages.NeedTypes | packages.NeedSyntax | packages.NeedTypesInfo,
+		Context: b.ctx,
+		Dir:     root,
+		Fset:    b.fset,
+		Tests:   true,
+	}, patterns...)
+	if err != nil {
+		return errors.Wrapf(err, "load packages of %s", root)
+	}
+
+	for _, pkg := range pkgs {
+		// generated main package of test binary
+		if strings.HasSuffix(pkg.ID, ".test") {
+			continue
+		}
+		for _, err := range pkg.Errors {
+			slog.Warn("package has errors", "package", pkg.ID, "err", err)
+		}
+		if pkg.TypesInfo == nil {
+			continue
+		}
+
+		slog.Debug("package loaded", "package", pkg.ID, "files", len(pkg.Syntax))
+		if err := b.index(pkg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// index records declarations and references of package objects.
+func (b *packagesBackend) index(pkg *packages.Package) error {
+	for _, f := range pkg.Syntax {
+		filename := b.fset.File(f.Pos()).Name()
+		if _, ok := b.syntax[filename]; !ok {
+			b.syntax[filename] = f
+		}
+	}
+
+	for ident, obj := range pkg.TypesInfo.Defs {
+		if obj == nil {
+			continue
+		}
+
+		loc, err := b.location(ident.Pos(), ident.End())
+		if err != nil {
+			return err
+		}
+		pos := docPosition{loc.URI, loc.Range.Start}
+		if _, ok := b.defs[pos]; !ok {
+			b.defs[pos] = obj
+			b.decls[b.key(obj)] = loc
+		}
+	}
+
+	for ident, obj := range pkg.TypesInfo.Uses {
+		loc, err := b.location(ident.Pos(), ident.End())
+		if err != nil {
+			return err
+		}
+		k := b.key(obj)
+		b.uses[k] = append(b.uses[k], loc)
+	}
+
+	// implementations are searched among types of package and its dependencies
+	b.addMethods(types.Universe.Lookup("error").(*types.TypeName))
+	var visit func(p *types.Package)
+	visit = func(p *types.Package) {
+		scope := p.Scope()
+		for _, name := range scope.Names() {
+			if obj, ok := scope.Lookup(name).(*types.TypeName); ok {
+				b.addMethods(obj)
+			}
+		}
+		for _, imp := range p.Imports() {
+			if !b.imported[imp.Path()] {
+				b.imported[imp.Path()] = true
+				visit(imp)
+			}
+		}
+	}
+	// loaded package is always visited, since its test variant declares more types
+	visit(pkg.Types)
+	return nil
+}
+
+// addMethods records named type by names of its methods.
+func (b *packagesBackend) addMethods(obj *types.TypeName) {
+	named, ok := obj.Type().(*types.Named)
+	if !ok || obj.IsAlias() || b.named[b.key(obj)] {
+		return
+	}
+	b.named[b.key(obj)] = true
+
+	mset := types.NewMethodSet(types.NewPointer(named))
+	if types.IsInterface(named) {
+		mset = types.NewMethodSet(named)
+	}
+	for sel := range mset.Methods() {
+		name := sel.Obj().Name()
+		b.methods[name] = append(b.methods[name], named)
+	}
+}
+
+// key returns key of object, objects of different package variants have the same key.
+func (b *packagesBackend) key(obj types.Object) objectKey {
+	switch o := obj.(type) {
+	case *types.Func:
+		obj = o.Origin()
+	case *types.Var:
+		obj = o.Origin()
+	}
+
+	if obj.Pkg() == nil {
+		// predeclared objects, e.g. error and its Error method
+		return objectKey("universe " + obj.Name())
+	}
+	if path, err := objectpath.For(obj); err == nil {
+		return objectKey(obj.Pkg().Path() + " " + string(path))
+	}
+	// local objects are not referenced from other packages
+	pos := b.fset.PositionFor(obj.Pos(), false)
+	return objectKey(fmt.Sprintf("%s:%d:%d", pos.Filename, pos.Line, pos.Column))
+}
+
+// source returns content of file.
+func (b *packagesBackend) source(filename string) ([]byte, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if src, ok := b.src[filename]; ok {
+		return src, nil
+	}
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "read file")
+	}
+	b.src[filename] = src
+	return src, nil
+}
+
+// utf16Len returns length of text in UTF-16 code units, which LSP counts characters in.
+func utf16Len(text []byte) int {
+	units := 0
+	for len(text) > 0 {
+		r, size := utf8.DecodeRune(text)
+		units += utf16.RuneLen(r)
+		text = text[size:]
+	}
+	return units
+}
+
+// location converts range in loaded file to LSP location.
+func (b *packagesBackend) location(start, end token.Pos) (lsp.Location, error) {
+	startPos, endPos := b.fset.PositionFor(start, false), b.fset.PositionFor(end, false)
+	src, err := b.source(startPos.Filename)
+	if err != nil {
+		return lsp.Location{}, err
+	}
+
+	position := func(pos token.Position) lsp.Position {
+		lineStart := pos.Offset - (pos.Column - 1)
+		return lsp.Position{Line: pos.Line - 1, Character: utf16Len(src[lineStart:min(pos.Offset, len(src))])}
+	}
+	return lsp.Location{
+		URI:   documentURI(b.workspaceDir, startPos.Filename),
+		Range: lsp.Range{Start: position(startPos), End: position(endPos)},
+	}, nil
+}
+
+func compareLocations(a, b lsp.Location) int {
+	return cmp.Or(
+		cmp.Compare(a.URI, b.URI),
+		cmp.Compare(a.Range.Start.Line, b.Range.Start.Line),
+		cmp.Compare(a.Range.Start.Character, b.Range.Start.Character),
+	)
+}
+
+// object returns object declared at location.
+func (b *packagesBackend) object(loc lsp.Location) (types.Object, bool) {
+	pos := docPosition{loc.URI, loc.Range.Start}
+	if obj, ok := b.defs[pos]; ok {
+		return obj, true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	obj, ok := b.external[pos]
+	return obj, ok
+}
+
+// TypeOf returns type of object declared at loc, nil if there is no such object.
+func (b *packagesBackend) TypeOf(loc lsp.Location) types.Type {
+	obj, ok := b.object(loc)
+	if !ok {
+		return nil
+	}
+	return obj.Type()
+}
+
+// declaration returns location of object declaration, objects declared
+// outside of loaded files are remembered, so that they can be queried.
+func (b *packagesBackend) declaration(obj types.Object) lsp.Location {
+	if loc, ok := b.decls[b.key(obj)]; ok {
+		return loc
+	}
+
+	// export data keeps only lines of declarations, column is found in source
+	pos := b.fset.PositionFor(obj.Pos(), false)
+	if rest, ok := strings.CutPrefix(pos.Filename, "$GOROOT"); ok {
+		pos.Filename = filepath.Join(b.goroot, rest)
+	}
+	start := lsp.Position{Line: pos.Line - 1, Character: max(pos.Column-1, 0)}
+	if src, err := b.source(pos.Filename); err == nil {
+		lines := bytes.SplitAfter(src, []byte("\n"))
+		if start.Line < len(lines) {
+			line := lines[start.Line]
+			if i := identIndex(line, obj.Name()); i != -1 {
+				start.Character = utf16Len(line[:i])
+			}
+		}
+	}
+	loc := lsp.Location{
+		URI: documentURI(b.workspaceDir, pos.Filename),
+		Range: lsp.Range{
+			Start: start,
+			End:   lsp.Position{Line: start.Line, Character: start.Character + utf16Len([]byte(obj.Name()))},
+		},
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.external[docPosition{loc.URI, start}] = obj
+	return loc
+}
+
+// identIndex returns index of first occurrence of identifier name in line, or -1.
+func identIndex(line []byte, name string) int {
+	isIdent := func(i int) bool {
+		r, _ := utf8.DecodeRune(line[i:])
+		return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+	}
+	for offset := 0; ; {
+		i := bytes.Index(line[offset:], []byte(name))
+		if i == -1 {
+			return -1
+		}
+		i += offset
+		end := i + len(name)
+		before := i > 0 && isIdent(i-1)
+		after := end < len(line) && isIdent(end)
+		if !before && !after {
+			return i
+		}
+		offset = end
+	}
+}
+
+func (b *packagesBackend) DocumentReferences(loc lsp.Location) ([]lsp.Location, error) {
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+
+	obj, ok := b.object(loc)
+	if !ok {
+		return nil, nil
+	}
+	return slices.Clone(b.uses[b.key(obj)]), nil
+}
+
+func (b *packagesBackend) Implementation(loc lsp.Location) ([]lsp.Location, error) {
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+
+	obj, ok := b.object(loc)
+	if !ok {
+		return nil, nil
+	}
+	fn, ok := obj.(*types.Func)
+	if !ok || fn.Signature().Recv() == nil {
+		return nil, nil
+	}
+
+	recv := fn.Signature().Recv().Type()
+	if ptr, ok := recv.(*types.Pointer); ok {
+		recv = ptr.Elem()
+	}
+	recvNamed, ok := recv.(*types.Named)
+	if !ok {
+		return nil, nil
+	}
+	recvIface, isIface := recv.Underlying().(*types.Interface)
+
+	implements := func(t *types.Named, iface *types.Interface) bool {
+		return types.Implements(t, iface) || types.Implements(types.NewPointer(t), iface)
+	}
+
+	var res []lsp.Location
+	for _, named := range b.methods[fn.Name()] {
+		var method types.Object
+		switch iface, ok := named.Underlying().(*types.Interface); {
+		// interface method is implemented by concrete methods
+		case isIface && !ok && implements(named, recvIface):
+			method, _, _ = types.LookupFieldOrMethod(named, true, fn.Pkg(), fn.Name())
+		// concrete method implements interface methods
+		case !isIface && ok && implements(recvNamed, iface):
+			method, _, _ = types.LookupFieldOrMethod(named, false, fn.Pkg(), fn.Name())
+		}
+		if method == nil {
+			continue
+		}
+
+		if impl := b.declaration(method); !slices.Contains(res, impl) && impl != loc {
+			res = append(res, impl)
+		}
+	}
+	slices.SortFunc(res, compareLocations)
+	return res, nil
+}
+
+func (b *packagesBackend) DocumentSymbol(filename string) ([]documentSymbol, error) {
+	if err := b.load(); err != nil {
+		return nil, err
+	}
+
+	filename = strings.TrimPrefix(string(documentURI(b.workspaceDir, filename)), "file://")
+	f, ok := b.syntax[filename]
+	if !ok {
+		// file is not part of any package, e.g. due to build constraints
+		var err error
+		if f, err = parser.ParseFile(b.fset, filename, nil, parser.SkipObjectResolution); err != nil {
+			return nil, errors.Wrap(err, "parse file")
+		}
+	}
+
+	return b.fileSymbols(f)
+}
+
+// fileSymbols returns symbols declared in file, the same way gopls does.
+func (b *packagesBackend) fileSymbols(f *ast.File) ([]documentSymbol, error) {
+	symbol := func(name string, kind lsp.SymbolKind, detail string, node, selection ast.Node, children []documentSymbol) (documentSymbol, error) {
+		rng, err := b.location(node.Pos(), node.End())
+		if err != nil {
+			return documentSymbol{}, err
+		}
+		selectionRng, err := b.location(selection.Pos(), selection.End())
+		if err != nil {
+			return documentSymbol{}, err
+		}
+		return documentSymbol{
+			Name:           name,
+			Detail:         detail,
+			Kind:           kind,
+			Tags:           nil,
+			Deprecated:     false,
+			Range:          rng.Range,
+			SelectionRange: selectionRng.Range,
+			Children:       children,
+		}, nil
+	}
+
+	var typeDetails func(expr ast.Expr) (lsp.SymbolKind, string, []documentSymbol, error)
+	fieldSymbols := func(fields *ast.FieldList, kind lsp.SymbolKind) ([]documentSymbol, error) {
+		var res []documentSymbol
+		for _, field := range fields.List {
+			_, detail, children, err := typeDetails(field.Type)
+			if err != nil {
+				return nil, err
+			}
+
+			if len(field.Names) == 0 {
+				// embedded field or interface, named by its type
+				name, selection := detail, ast.Node(field.Type)
+				if id := embeddedIdent(field.Type); id != nil {
+					name, selection = id.Name, id
+				}
+				s, err := symbol(name, lsp.SymbolKindField, detail, field, selection, children)
+				if err != nil {
+					return nil, err
+				}
+				res = append(res, s)
+				continue
+			}
+
+			for _, name := range field.Names {
+				s, err := symbol(name.Name, kind, detail, field, name, children)
+				if err != nil {
+					return nil, err
+				}
+				res = append(res, s)
+			}
+		}
+		return res, nil
+	}
+	typeDetails = func(expr ast.Expr) (lsp.SymbolKind, string, []documentSymbol, error) {
+		switch expr := expr.(type) {
+		case *ast.StructType:
+			children, err := fieldSymbols(expr.Fields, lsp.SymbolKindField)
+			if len(children) == 0 {
+				return lsp.SymbolKindStruct, "struct{}", nil, err
+			}
+			return lsp.SymbolKindStruct, "struct{...}", children, err
+		case *ast.InterfaceType:
+			children, err := fieldSymbols(expr.Methods, lsp.SymbolKindMethod)
+			if len(children) == 0 {
+				return lsp.SymbolKindInterface, "interface{}", nil, err
+			}
+			return lsp.SymbolKindInterface, "interface{...}", children, err
+		case *ast.FuncType:
+			return lsp.SymbolKindFunction, types.ExprString(expr), nil, nil
+		default:
+			return lsp.SymbolKindClass, types.ExprString(expr), nil, nil
+		}
+	}
+
+	var res []documentSymbol
+	for _, decl := range f.Decls {
+		switch decl := decl.(type) {
+		case *ast.FuncDecl:
+			name, kind := decl.Name.Name, lsp.SymbolKindFunction
+			if decl.Recv != nil && len(decl.Recv.List) > 0 {
+				name, kind = fmt.Sprintf("(%s).%s", types.ExprString(decl.Recv.List[0].Type), name), lsp.SymbolKindMethod
+			}
+			s, err := symbol(name, kind, types.ExprString(decl.Type), decl, decl.Name, nil)
+			if err != nil {
+				return nil, err
+			}
+			res = append(res, s)
+		case *ast.GenDecl:
+			for _, spec := range decl.Specs {
+				switch spec := spec.(type) {
+				case *ast.TypeSpec:
+					kind, detail, children, err := typeDetails(spec.Type)
+					if err != nil {
+						return nil, err
+					}
+					s, err := symbol(spec.Name.Name, kind, detail, spec, spec.Name, children)
+					if err != nil {
+						return nil, err
+					}
+					res = append(res, s)
+				case *ast.ValueSpec:
+					kind := lsp.SymbolKindVariable
+					if decl.Tok == token.CONST {
+						kind = lsp.SymbolKindConstant
+					}
+					detail := ""
+					if spec.Type != nil {
+						detail = types.ExprString(spec.Type)
+					}
+					for _, name := range spec.Names {
+						// gopls omits blank identifiers
+						if name.Name == "_" {
+							continue
+						}
+						s, err := symbol(name.Name, kind, detail, spec, name, nil)
+						if err != nil {
+							return nil, err
+						}
+						res = append(res, s)
+					}
+				}
+			}
+		}
+	}
+	return res, nil
+}
+
+// embeddedIdent returns name of embedded type, e.g. T for *pkg.T[K].
+func embeddedIdent(expr ast.Expr) *ast.Ident {
+	switch expr := expr.(type) {
+	case *ast.StarExpr:
+		return embeddedIdent(expr.X)
+	case *ast.IndexExpr:
+		return embeddedIdent(expr.X)
+	case *ast.IndexListExpr:
+		return embeddedIdent(expr.X)
+	case *ast.SelectorExpr:
+		return expr.Sel
+	case *ast.Ident:
+		return expr
+	default:
+		return nil
+	}
+}
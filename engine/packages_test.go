@@ -0,0 +1,92 @@
+package engine
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/rprtr258/punused/internal/lsp"
+)
+
+// TestPackagesBackend checks that packages backend answers queries the same way gopls does.
+func TestPackagesBackend(t *testing.T) {
+	// workspace is module root, testdata is shared with tests of punused command
+	wd, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filenames, err := fs.Glob(os.DirFS(wd), "testdata/*/*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gopls, err := newClient(t.Context(), wd, 4)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer gopls.Close()
+	packages := newPackagesBackend(t.Context(), wd)
+	for _, b := range []backend{gopls, packages} {
+		for _, filename := range filenames {
+			if err := b.Open(filename); err != nil {
+				t.Fatal(err.Error())
+			}
+		}
+	}
+
+	// references are compared the way runner gets them, including references to implementations.
+	// Locations outside of testdata module are ignored: gopls also finds unexported interfaces of
+	// standard library and references from their implementations in other modules.
+	testdata := documentURI(wd, "testdata") + "/"
+	outside := func(loc lsp.Location) bool { return !strings.HasPrefix(string(loc.URI), string(testdata)) }
+	references := func(b backend, s symbol) ([]lsp.Location, []lsp.Location) {
+		t.Helper()
+		r := &runner{client: b, lookups: map[nodeKey]*lookup{}}
+		refs, impls, err := r.references(s)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		refs, impls = slices.DeleteFunc(refs, outside), slices.DeleteFunc(impls, outside)
+		slices.SortFunc(refs, compareLocations)
+		slices.SortFunc(impls, compareLocations)
+		return refs, impls
+	}
+
+	for _, filename := range filenames {
+		want, err := gopls.DocumentSymbol(filename)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		got, err := packages.DocumentSymbol(filename)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Errorf("%s: unexpected symbols\n%s", filename, diff)
+			continue
+		}
+
+		var check func(symbols []documentSymbol, parent string)
+		check = func(symbols []documentSymbol, parent string) {
+			for _, ds := range symbols {
+				s := symbol{ds, documentURI(wd, filename), parent}
+				wantRefs, wantImpls := references(gopls, s)
+				gotRefs, gotImpls := references(packages, s)
+				if diff := cmp.Diff(wantRefs, gotRefs, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("%s %s: unexpected references\n%s", filename, s.QualifiedName(), diff)
+				}
+				if diff := cmp.Diff(wantImpls, gotImpls, cmpopts.EquateEmpty()); diff != "" {
+					t.Errorf("%s %s: unexpected implementations\n%s", filename, s.QualifiedName(), diff)
+				}
+				check(ds.Children, s.QualifiedName())
+			}
+		}
+		check(got, "")
+	}
+}
@@ -1,14 +1,14 @@
-package main
+package engine
 
 import "github.com/rprtr258/punused/internal/lsp"
 
-type SymbolTag float64
+type symbolTag float64
 
 // Represents programming constructs like variables, classes, interfaces etc.
 // that appear in a document. Document symbols can be hierarchical and they
 // have two ranges: one that encloses its definition and one that points to
 // its most interesting range, e.g. the range of an identifier.
-type DocumentSymbol struct {
+type documentSymbol struct {
 	// The name of this symbol. Will be displayed in the user interface and therefore must not be
 	// an empty string or a string only consisting of white spaces.
 	Name string `json:"name"`
@@ -19,7 +19,7 @@ type DocumentSymbol struct {
 	// Tags for this document symbol.
 	//
 	// @since 3.16.0
-	Tags []SymbolTag `json:"tags,omitempty"`
+	Tags []symbolTag `json:"tags,omitempty"`
 	// Deprecated: Indicates if this symbol is deprecated. Use tags instead.
 	Deprecated bool `json:"deprecated,omitempty"`
 	// The range enclosing this symbol not including leading/trailing whitespace but everything else
@@ -30,5 +30,5 @@ type DocumentSymbol struct {
 	// Must be contained by the the `range`.
 	SelectionRange lsp.Range `json:"selectionRange"`
 	// Children of this symbol, e.g. properties of a class.
-	Children []DocumentSymbol `json:"children,omitempty"`
+	Children []documentSymbol `json:"children,omitempty"`
 }
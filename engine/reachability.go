@@ -0,0 +1,280 @@
+package engine
+
+import (
+	"cmp"
+	"go/ast"
+	"maps"
+	"path"
+	"slices"
+	"strings"
+
+	"github.com/rprtr258/punused/internal/lsp"
+)
+
+// node of reference graph is a top-level symbol together with its children.
+type node struct {
+	Symbol symbol
+	// Refs are references to symbol and its children.
+	Refs []lsp.Location
+	// Referenced is set if symbol itself is referenced, unreferenced symbols are reported as unused instead.
+	Referenced bool
+	// RootReason is why symbol is used implicitly, e.g. it is an entrypoint or is exported API.
+	// Empty if symbol is not a root.
+	RootReason string
+}
+
+// nodeKey identifies top-level symbol, so that symbols visited again are not duplicated.
+type nodeKey struct {
+	URI lsp.URI
+	Pos lsp.Position
+}
+
+func newNodeKey(s symbol) nodeKey {
+	return nodeKey{s.URI, s.SelectionRange.Start}
+}
+
+// addNode adds top-level symbol to reference graph, making it current node
+// which references to symbol children are added to.
+func (r *runner) addNode(cfg *dirConfig, s symbol) error {
+	reason, err := r.rootReason(cfg, s)
+	if err != nil {
+		return err
+	}
+
+	r.current = &node{Symbol: s, Refs: nil, Referenced: false, RootReason: reason}
+	r.nodes[newNodeKey(s)] = r.current
+	return nil
+}
+
+// rootReason checks whether symbol is used implicitly: blank variables,
+// configured roots and exported API of non-internal packages, if enabled.
+// Entrypoints and suppressed symbols are marked as roots when visited.
+func (r *runner) rootReason(cfg *dirConfig, s symbol) (string, error) {
+	switch {
+	case s.Kind == lsp.SymbolKindVariable && s.Name == "_":
+		return "blank variable", nil
+	case slices.Contains(cfg.Roots, s.QualifiedName()):
+		return "configured root", nil
+	case !cfg.ExportedRoots:
+		return "", nil
+	}
+
+	exported, err := r.isExportedAPI(s)
+	if err != nil || !exported {
+		return "", err
+	}
+	return "exported API", nil
+}
+
+// isExportedAPI checks whether symbol can be used by other modules.
+func (r *runner) isExportedAPI(s symbol) (bool, error) {
+	if !ast.IsExported(simpleName(s)) || strings.HasSuffix(string(s.URI), "_test.go") {
+		return false, nil
+	}
+	if s.Kind == lsp.SymbolKindMethod {
+		// receiver type, e.g. T for (*T[K]).Method
+		recv, _, _ := strings.Cut(s.Name, ".")
+		recv, _, _ = strings.Cut(strings.TrimLeft(recv, "(*"), "[")
+		if !ast.IsExported(strings.TrimSuffix(recv, ")")) {
+			return false, nil
+		}
+	}
+
+	filename, err := relPath(r.cfg.WorkspaceDir, s.URI)
+	if err != nil {
+		return false, err
+	}
+	if slices.Contains(strings.Split(path.Dir(filename), "/"), "internal") {
+		return false, nil
+	}
+
+	pkg, err := r.packageName(s.URI)
+	if err != nil {
+		return false, err
+	}
+	return pkg != "main", nil
+}
+
+// markRoot marks top-level symbol as root.
+func (r *runner) markRoot(s symbol, reason string) {
+	if s.Parent == "" && r.current != nil {
+		r.current.RootReason = reason
+	}
+}
+
+// addRefs adds references to symbol to current node.
+func (r *runner) addRefs(s symbol, refs []lsp.Location) {
+	if r.current == nil {
+		return
+	}
+	if s.Parent == "" {
+		r.current.Referenced = len(refs) > 0
+	}
+	r.current.Refs = appendNew(r.current.Refs, refs...)
+}
+
+func rangeContains(rng lsp.Range, pos lsp.Position) bool {
+	comparePos := func(a, b lsp.Position) int {
+		return cmp.Or(cmp.Compare(a.Line, b.Line), cmp.Compare(a.Character, b.Character))
+	}
+	return comparePos(rng.Start, pos) <= 0 && comparePos(pos, rng.End) <= 0
+}
+
+// graph of references between top-level symbols.
+type graph struct {
+	// nodes sorted by position
+	nodes []*node
+	byURI map[lsp.URI][]*node
+	// uses are edges from referencing symbol to referenced one
+	uses map[*node][]*node
+	// reachedFrom maps reachable nodes to node they are reached from, roots are mapped to nil
+	reachedFrom map[*node]*node
+}
+
+// graph builds reference graph from visited symbols and finds symbols reachable from roots.
+// Must be called only after all diagnostics are evaluated.
+func (r *runner) graph() *graph {
+	g := &graph{
+		nodes: slices.SortedFunc(maps.Values(r.nodes), func(a, b *node) int {
+			return cmp.Or(
+				cmp.Compare(a.Symbol.URI, b.Symbol.URI),
+				cmp.Compare(a.Symbol.SelectionRange.Start.Line, b.Symbol.SelectionRange.Start.Line),
+				cmp.Compare(a.Symbol.SelectionRange.Start.Character, b.Symbol.SelectionRange.Start.Character),
+			)
+		}),
+		byURI:       map[lsp.URI][]*node{},
+		uses:        map[*node][]*node{},
+		reachedFrom: map[*node]*node{},
+	}
+	for _, n := range g.nodes {
+		g.byURI[n.Symbol.URI] = append(g.byURI[n.Symbol.URI], n)
+	}
+
+	for _, n := range g.nodes {
+		for _, ref := range n.Refs {
+			switch user := g.enclosing(ref); user {
+			case nil:
+				if n.RootReason == "" {
+					n.RootReason = "referenced from code which is not checked"
+				}
+			case n:
+			default:
+				g.uses[user] = append(g.uses[user], n)
+			}
+		}
+	}
+
+	g.reach(slices.DeleteFunc(slices.Clone(g.nodes), func(n *node) bool { return n.RootReason == "" })...)
+	return g
+}
+
+// enclosing returns top-level symbol containing location, nil if location is outside of checked symbols.
+func (g *graph) enclosing(loc lsp.Location) *node {
+	for _, n := range g.byURI[loc.URI] {
+		if rangeContains(n.Symbol.Range, loc.Range.Start) {
+			return n
+		}
+	}
+	return nil
+}
+
+// reach marks nodes reachable from roots using breadth first search, so that paths are shortest.
+func (g *graph) reach(roots ...*node) {
+	queue := slices.DeleteFunc(roots, g.isReached)
+	for _, n := range queue {
+		g.reachedFrom[n] = nil
+	}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, used := range g.uses[n] {
+			if !g.isReached(used) {
+				g.reachedFrom[used] = n
+				queue = append(queue, used)
+			}
+		}
+	}
+}
+
+func (g *graph) isReached(n *node) bool {
+	_, ok := g.reachedFrom[n]
+	return ok
+}
+
+// pathFromRoot returns path from root to reachable node, nil if node is unreachable.
+func (g *graph) pathFromRoot(n *node) []*node {
+	if !g.isReached(n) {
+		return nil
+	}
+
+	var res []*node
+	for ; n != nil; n = g.reachedFrom[n] {
+		res = append(res, n)
+	}
+	slices.Reverse(res)
+	return res
+}
+
+// unreachable returns diagnostics for symbols which are referenced, but only from
+// symbols not reachable from roots, e.g. functions calling each other, but not called
+// from anywhere else. Diagnostics are grouped by clusters of symbols referencing each other.
+func (r *runner) unreachable(g *graph) ([]diagnostic, error) {
+	isUnreachable := func(n *node) bool { return !g.isReached(n) && n.Referenced }
+	var unreachable []diagnostic
+	for _, n := range g.nodes {
+		if !isUnreachable(n) {
+			continue
+		}
+
+		cfg, err := r.configForURI(n.Symbol.URI)
+		if err != nil {
+			return nil, err
+		}
+		diag := diagnostic{
+			Symbol:     n.Symbol,
+			Code:       CodeUnreachable,
+			References: n.Refs,
+			Severity:   cfg.Severities[CodeUnreachable],
+		}
+		if diag.Severity == SeverityOff {
+			continue
+		}
+		if r.isSuppressed(cfg, diag) {
+			// intentionally kept symbol keeps symbols it uses alive
+			n.RootReason = "suppressed"
+			g.reach(n)
+			continue
+		}
+		unreachable = append(unreachable, diag)
+	}
+	// symbols could become reachable from suppressed ones
+	unreachable = slices.DeleteFunc(unreachable, func(diag diagnostic) bool {
+		return g.isReached(r.nodes[newNodeKey(diag.Symbol)])
+	})
+
+	// group into clusters of symbols connected by references in either direction
+	usedBy := map[*node][]*node{}
+	for user, used := range g.uses {
+		for _, n := range used {
+			usedBy[n] = append(usedBy[n], user)
+		}
+	}
+	cluster := map[*node]int{}
+	for i, diag := range unreachable {
+		queue := []*node{r.nodes[newNodeKey(diag.Symbol)]}
+		for len(queue) > 0 {
+			n := queue[0]
+			queue = queue[1:]
+			if _, ok := cluster[n]; ok || !isUnreachable(n) {
+				continue
+			}
+			cluster[n] = i
+			queue = append(queue, g.uses[n]...)
+			queue = append(queue, usedBy[n]...)
+		}
+	}
+	slices.SortStableFunc(unreachable, func(a, b diagnostic) int {
+		return cmp.Compare(cluster[r.nodes[newNodeKey(a.Symbol)]], cluster[r.nodes[newNodeKey(b.Symbol)]])
+	})
+	return unreachable, nil
+}
@@ -0,0 +1,516 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"go/ast"
+	"go/token"
+	"io/fs"
+	"iter"
+	"log/slog"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gobwas/glob"
+	"github.com/rprtr258/fun"
+
+	"github.com/rprtr258/punused/internal/exclude"
+	"github.com/rprtr258/punused/internal/lsp"
+)
+
+type runConfig struct {
+	// ConfigFile is absolute path of config file, empty if default config is used.
+	ConfigFile   string
+	WorkspaceDir string
+	// FilenameMatchers select files to check, file is checked if it matches any of them.
+	FilenameMatchers []glob.Glob
+	// Config is root config, nested configs are loaded while walking workspace.
+	Config fileConfig
+	// SkipTests makes _test.go files not checked, references from them are still counted.
+	SkipTests bool
+	// ReportUnexportable enables reporting of exported symbols used only in their own package.
+	ReportUnexportable bool
+	// FailOnStale makes stale suppressions fail the run, unless severity is set in config.
+	FailOnStale bool
+	// Concurrency is max number of reference queries in flight.
+	Concurrency int
+}
+
+type runner struct {
+	// Filter holds run config, configs of directories and suppression directives of visited files
+	*Filter
+	client backend
+	// cancel releases context backend is started with
+	cancel context.CancelFunc
+	// packageNames of already parsed files
+	packageNames map[lsp.URI]string
+	fset         *token.FileSet
+	// parsedFiles are ASTs of already parsed files
+	parsedFiles map[lsp.URI]*ast.File
+	// nodes of reference graph by top-level symbol
+	nodes map[nodeKey]*node
+	// current is node of top-level symbol being visited
+	current *node
+	// lookups are reference queries started ahead of symbol evaluation
+	lookups map[nodeKey]*lookup
+	// since is revision changes since which are checked, nil if whole workspace is checked
+	since *revision
+}
+
+// lookup is result of reference queries for symbol, which is ready once done is closed.
+type lookup struct {
+	symbol symbol
+	done   chan struct{}
+	// refs are references to symbol and to symbols in impls
+	refs  []lsp.Location
+	impls []lsp.Location
+	err   error
+}
+
+func (r *runner) Stop() error {
+	defer r.cancel()
+	err := r.client.Close()
+	if r.since != nil {
+		if errSince := r.since.Close(); err == nil {
+			err = errSince
+		}
+	}
+	return err
+}
+
+// analyze yields diagnostics of walked files, then unreachable symbols and stale
+// suppressions. Reference graph is returned unless analysis is stopped.
+// If only changes since revision are checked, graph is not built, see analyzeSince.
+func (r *runner) analyze(yield func(diagnostic, error) bool) *graph {
+	files := r.Walk
+	if r.since != nil {
+		files = r.changed
+	}
+	for diag, err := range r.diagnostics(r.symbols(files)) {
+		if !yield(diag, err) || err != nil {
+			return nil
+		}
+	}
+
+	if r.since != nil {
+		r.analyzeSince(yield)
+		return nil
+	}
+
+	g := r.graph()
+	unreachable, err := r.unreachable(g)
+	if err != nil {
+		yield(diagnostic{}, err)
+		return nil
+	}
+	stale, err := r.staleSuppressions()
+	if err != nil {
+		yield(diagnostic{}, err)
+		return nil
+	}
+	for _, diag := range slices.Concat(unreachable, stale) {
+		if !yield(diag, nil) {
+			return nil
+		}
+	}
+	return g
+}
+
+func (r *runner) isFileExcluded(filename string) (bool, error) {
+	if r.cfg.SkipTests && strings.HasSuffix(filename, "_test.go") {
+		slog.Debug("file excluded", "file", filename, "rule", "-tests=false")
+		return true, nil
+	}
+
+	if !slices.ContainsFunc(r.cfg.FilenameMatchers, func(g glob.Glob) bool { return g.Match(filename) }) {
+		slog.Debug("file excluded", "file", filename, "rule", "not matched by patterns")
+		r.excludedDirs[path.Dir(filename)] = true
+		return true, nil
+	}
+
+	excluded, err := r.isPathExcluded(filename)
+	if excluded {
+		r.excludedDirs[path.Dir(filename)] = true
+	}
+	return excluded, err
+}
+
+func (r *runner) Walk(yield func(string, error) bool) {
+	if err := filepath.Walk(r.cfg.WorkspaceDir, func(path string, info fs.FileInfo, err error) error {
+		if err != nil || info == nil {
+			return err
+		}
+		if info.IsDir() {
+			if strings.HasPrefix(info.Name(), ".") {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if filepath.Ext(path) != ".go" {
+			return nil
+		}
+
+		filename := strings.TrimPrefix(filepath.ToSlash(strings.TrimPrefix(path, r.cfg.WorkspaceDir)), "/")
+		if excluded, err := r.isFileExcluded(filename); err != nil {
+			return err
+		} else if excluded {
+			return nil
+		}
+
+		if !yield(filename, nil) {
+			return filepath.SkipAll
+		}
+
+		return nil
+	}); err != nil {
+		_ = yield("", err)
+	}
+}
+
+func (r *runner) symbols(filenames iter.Seq2[string, error]) iter.Seq2[symbol, error] {
+	return func(yield func(symbol, error) bool) {
+		for filename, err := range filenames {
+			if err != nil {
+				_ = yield(symbol{}, err)
+				return
+			}
+
+			// TODO: get type parameters
+			symbols, err := r.client.DocumentSymbol(filename)
+			if err != nil {
+				_ = yield(symbol{}, fmt.Errorf("failed to get symbols: %w", err))
+				return
+			}
+
+			uri := documentURI(r.cfg.WorkspaceDir, filename)
+			if r.directives[uri], err = parseDirectives(strings.TrimPrefix(string(uri), "file://")); err != nil {
+				_ = yield(symbol{}, fmt.Errorf("failed to get suppression directives: %w", err))
+				return
+			}
+
+			if slog.Default().Enabled(context.Background(), slog.LevelDebug) {
+				names := make([]string, len(symbols))
+				for i, s := range symbols {
+					names[i] = s.Name
+				}
+				slog.Debug("file symbols", "file", filename, "symbols", names)
+			}
+			for _, s := range symbols {
+				if !yield(symbol{s, uri, ""}, nil) {
+					return
+				}
+			}
+		}
+	}
+}
+
+// exclusionReason returns why symbol is not checked, empty if it is checked.
+func (r *runner) exclusionReason(cfg *dirConfig, s symbol) (string, error) {
+	if kind := strings.ToLower(s.Kind.String()); !cfg.checksKind(kind) {
+		return kindExclusionReason(kind), nil
+	}
+
+	// TODO: skip public symbols outside of internal subpackage
+	// TODO: skip if Public symbol outside of internal package
+	// if len(s) > 0 && s[0] >= 'A' && s[0] <= 'Z'
+
+	switch s.Kind {
+	case lsp.SymbolKindFunction:
+		if s.Parent != "" {
+			return "", nil
+		}
+		if entrypoint, err := r.isEntrypoint(s); err != nil || !entrypoint {
+			return "", err
+		}
+		return "called by Go toolchain", nil
+	case lsp.SymbolKindMethod:
+		// Struct methods' Name comes on the form  (MyType).MyMethod.
+		if _, method, isMethod := strings.Cut(s.Name, "."); isMethod && exclude.IsWellKnownMethod(method, s.Detail, cfg.Interfaces) {
+			return "implements well-known interface", nil
+		}
+	}
+	return "", nil
+}
+
+// Code identifies kind of diagnostic, it is used in suppressions and config.
+type Code string
+
+const (
+	CodeTestOnly         Code = "EU1001"
+	CodeUnused           Code = "EU1002"
+	CodeStaleSuppression Code = "EU1003"
+	CodeUnexportable     Code = "EU1004"
+	CodeUnreachable      Code = "EU1005"
+)
+
+// Codes are all codes punused reports.
+var Codes = []Code{CodeTestOnly, CodeUnused, CodeStaleSuppression, CodeUnexportable, CodeUnreachable}
+
+// Message returns description of diagnostic, e.g. "unused" for CodeUnused.
+func (c Code) Message() string {
+	switch c {
+	case CodeTestOnly:
+		return "used in test only"
+	case CodeUnused:
+		return "unused"
+	case CodeStaleSuppression:
+		return "stale"
+	case CodeUnexportable:
+		return "used only in its own package"
+	case CodeUnreachable:
+		return "unreachable"
+	default:
+		return string(c)
+	}
+}
+
+type diagnostic struct {
+	// Symbol diagnostic is about. For stale suppressions it is the
+	// suppression itself: Name is its text and range points to it.
+	Symbol symbol
+	Code   Code
+	// References found for symbol, empty for unused symbols.
+	References []lsp.Location
+	Severity   Severity
+}
+
+func (d diagnostic) kind() string {
+	if d.Code == CodeStaleSuppression {
+		return "suppression"
+	}
+	return strings.ToLower(d.Symbol.Kind.String())
+}
+
+func (r *runner) subdiagnostics(s symbol, yield func(diagnostic, error) bool) bool {
+	cfg, err := r.configForURI(s.URI)
+	if err != nil {
+		yield(diagnostic{}, err)
+		return false
+	}
+	if s.Parent == "" {
+		if err := r.addNode(cfg, s); err != nil {
+			yield(diagnostic{}, err)
+			return false
+		}
+	}
+
+	// symbols of kinds not checked are skipped together with their children
+	if reason, err := r.exclusionReason(cfg, s); err != nil {
+		yield(diagnostic{}, err)
+		return false
+	} else if reason != "" {
+		slog.Debug("symbol excluded", "symbol", s.QualifiedName(), "uri", s.URI, "rule", reason)
+		r.markRoot(s, reason)
+		r.markSkipped(cfg, s)
+		return true
+	}
+	r.markChecked(s)
+
+	// TODO: ignore fields check if whole struct is unused
+	// TODO: ignore methods check if whole interface is unused
+	// TODO: ignore wrapper of symbol types if their const values are used
+
+	refs, impls, err := r.references(s)
+	if err != nil {
+		yield(diagnostic{}, err)
+		return false
+	}
+	r.addRefs(s, refs)
+	slog.Debug("symbol references", "symbol", s.QualifiedName(), "uri", s.URI, "references", len(refs), "implementations", len(impls))
+
+	var diag diagnostic
+	switch {
+	case len(refs) == 0:
+		diag = diagnostic{Symbol: s, Code: CodeUnused}
+	case !slices.ContainsFunc(refs, func(ref lsp.Location) bool { return !strings.HasSuffix(string(ref.URI), "_test.go") }):
+		diag = diagnostic{Symbol: s, Code: CodeTestOnly, References: refs}
+	case r.cfg.ReportUnexportable && canBeUnexported(s):
+		// method implementing interface from other package must stay exported, as well as
+		// interface method implemented in other package
+		ownOnly, err := r.isUsedInOwnPackageOnly(s, slices.Concat(refs, impls))
+		if err != nil {
+			yield(diagnostic{}, err)
+			return false
+		}
+		if ownOnly {
+			diag = diagnostic{Symbol: s, Code: CodeUnexportable, References: refs}
+		}
+	}
+
+	diag.Severity = cfg.Severities[diag.Code]
+	cont := true
+	if diag.Code != "" && diag.Severity != SeverityOff {
+		if !r.isSuppressed(cfg, diag) {
+			cont = yield(diag, nil)
+		} else if diag.Code == CodeUnused || diag.Code == CodeTestOnly {
+			// intentionally kept symbol keeps symbols it uses alive
+			r.markRoot(s, "suppressed")
+		}
+	}
+	return cont && fun.All(func(ch documentSymbol) bool {
+		return r.subdiagnostics(symbol{ch, s.URI, s.QualifiedName()}, yield)
+	}, s.Children...)
+}
+
+// references returns references to symbol, including references through related interface
+// or concrete methods, and locations of such methods. Result of query started by prefetch
+// is used if any.
+func (r *runner) references(s symbol) ([]lsp.Location, []lsp.Location, error) {
+	key := newNodeKey(s)
+	l, ok := r.lookups[key]
+	if !ok {
+		l = newLookup(s)
+		r.run(context.Background(), l)
+	}
+	delete(r.lookups, key)
+
+	<-l.done
+	return l.refs, l.impls, l.err
+}
+
+// prefetch starts reference queries for checked symbols and their children,
+// so that they run concurrently in at most Concurrency workers, while symbols are
+// evaluated in order. Queries not started yet are abandoned once ctx is cancelled.
+func (r *runner) prefetch(ctx context.Context, cfg *dirConfig, symbols ...symbol) {
+	var queue []*lookup
+	var enqueue func(symbols []symbol)
+	enqueue = func(symbols []symbol) {
+		for _, s := range symbols {
+			// excluded symbols are skipped together with their children, error is
+			// reported once symbol is evaluated
+			if reason, err := r.exclusionReason(cfg, s); err != nil || reason != "" {
+				continue
+			}
+
+			l := newLookup(s)
+			r.lookups[newNodeKey(s)] = l
+			queue = append(queue, l)
+			enqueue(fun.Map[symbol](func(ch documentSymbol) symbol {
+				return symbol{ch, s.URI, s.QualifiedName()}
+			}, s.Children...))
+		}
+	}
+	enqueue(symbols)
+
+	jobs := make(chan *lookup, len(queue))
+	for _, l := range queue {
+		jobs <- l
+	}
+	close(jobs)
+	for range min(max(r.cfg.Concurrency, 1), len(queue)) {
+		go func() {
+			for l := range jobs {
+				r.run(ctx, l)
+			}
+		}()
+	}
+}
+
+func newLookup(s symbol) *lookup {
+	return &lookup{symbol: s, done: make(chan struct{}), refs: nil, impls: nil, err: nil}
+}
+
+// run queries references of symbol of lookup, unless ctx is cancelled, and marks lookup done.
+func (r *runner) run(ctx context.Context, l *lookup) {
+	defer close(l.done)
+	if err := ctx.Err(); err != nil {
+		l.err = err
+		return
+	}
+
+	refs, err := r.client.DocumentReferences(lsp.Location{URI: l.symbol.URI, Range: l.symbol.SelectionRange})
+	if err != nil {
+		l.err = fmt.Errorf("failed to get references: %w", err)
+		return
+	}
+
+	impls, implRefs, err := r.implementations(l.symbol)
+	if err != nil {
+		l.err = fmt.Errorf("failed to get implementations: %w", err)
+		return
+	}
+	l.refs, l.impls = appendNew(refs, implRefs...), impls
+}
+
+// implementations returns locations of interface methods implemented by concrete method,
+// or of concrete methods implementing interface method, and references to them.
+// Method is used if either it or any of related methods is referenced.
+func (r *runner) implementations(s symbol) ([]lsp.Location, []lsp.Location, error) {
+	if s.Kind != lsp.SymbolKindMethod {
+		return nil, nil, nil
+	}
+
+	self := lsp.Location{URI: s.URI, Range: s.SelectionRange}
+	impls, err := r.client.Implementation(self)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var refs []lsp.Location
+	for _, impl := range impls {
+		implRefs, err := r.client.DocumentReferences(impl)
+		if err != nil {
+			return nil, nil, err
+		}
+		refs = appendNew(refs, slices.DeleteFunc(implRefs, func(ref lsp.Location) bool { return ref == self })...)
+	}
+	return impls, refs, nil
+}
+
+// appendNew appends locations not yet present in locs.
+func appendNew(locs []lsp.Location, more ...lsp.Location) []lsp.Location {
+	for _, loc := range more {
+		if !slices.Contains(locs, loc) {
+			locs = append(locs, loc)
+		}
+	}
+	return locs
+}
+
+func (r *runner) diagnostics(symbols iter.Seq2[symbol, error]) iter.Seq2[diagnostic, error] {
+	return func(yield func(diagnostic, error) bool) {
+		// symbols of file are evaluated in order after queries for whole file are started
+		var file []symbol
+		flush := func() bool {
+			if len(file) == 0 {
+				return true
+			}
+
+			cfg, err := r.configForURI(file[0].URI)
+			if err != nil {
+				yield(diagnostic{}, err)
+				return false
+			}
+			// queries of file, which are not needed anymore, e.g. since iteration
+			// is stopped, are cancelled
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			r.prefetch(ctx, cfg, file...)
+			defer clear(r.lookups)
+
+			for _, s := range file {
+				if !r.subdiagnostics(s, yield) {
+					return false
+				}
+			}
+			file = file[:0]
+			return true
+		}
+
+		for s, err := range symbols {
+			if err != nil {
+				yield(diagnostic{}, err)
+				return
+			}
+
+			if len(file) > 0 && file[0].URI != s.URI && !flush() {
+				return
+			}
+			file = append(file, s)
+		}
+		flush()
+	}
+}
@@ -0,0 +1,94 @@
+package engine
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rprtr258/punused/internal/lsp"
+)
+
+// blockingBackend answers reference queries once release is closed, counting queries in flight.
+type blockingBackend struct {
+	backend
+	release chan struct{}
+
+	mu                      sync.Mutex
+	started, inFlight, peak int
+}
+
+func (b *blockingBackend) DocumentReferences(lsp.Location) ([]lsp.Location, error) {
+	b.mu.Lock()
+	b.started++
+	b.inFlight++
+	b.peak = max(b.peak, b.inFlight)
+	b.mu.Unlock()
+
+	<-b.release
+
+	b.mu.Lock()
+	b.inFlight--
+	b.mu.Unlock()
+	return nil, nil
+}
+
+// TestPrefetchBounded checks that reference queries run in at most Concurrency workers
+// and queries not started yet are abandoned once prefetch context is cancelled.
+func TestPrefetchBounded(t *testing.T) {
+	const concurrency, count = 2, 10
+	b := &blockingBackend{release: make(chan struct{})}
+	r := &runner{Filter: newFilter(runConfig{Concurrency: concurrency}), client: b, lookups: map[nodeKey]*lookup{}}
+
+	symbols := make([]symbol, count)
+	for i := range symbols {
+		symbols[i] = symbol{documentSymbol{
+			Name: fmt.Sprintf("v%d", i),
+			Kind: lsp.SymbolKindVariable,
+			SelectionRange: lsp.Range{
+				Start: lsp.Position{Line: i, Character: 0},
+				End:   lsp.Position{Line: i, Character: 2},
+			},
+		}, "file:///p.go", ""}
+	}
+
+	ctx, cancel := context.WithCancel(t.Context())
+	r.prefetch(ctx, &dirConfig{}, symbols...)
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	close(b.release)
+
+	for _, s := range symbols {
+		<-r.lookups[newNodeKey(s)].done
+	}
+	if b.peak != concurrency || b.started != concurrency {
+		t.Errorf("expected %d queries to be started, started %d with %d at once", concurrency, b.started, b.peak)
+	}
+	if _, _, err := r.references(symbols[count-1]); err != context.Canceled {
+		t.Errorf("expected abandoned query to fail with %v, got %v", context.Canceled, err)
+	}
+}
+
+// TestPrefetchSkipsExcluded checks that references of symbols which are not checked are not queried.
+func TestPrefetchSkipsExcluded(t *testing.T) {
+	b := &blockingBackend{release: make(chan struct{})}
+	close(b.release)
+	r := &runner{Filter: newFilter(runConfig{Concurrency: 2}), client: b, lookups: map[nodeKey]*lookup{}}
+
+	at := func(line int) lsp.Range {
+		return lsp.Range{Start: lsp.Position{Line: line, Character: 0}, End: lsp.Position{Line: line, Character: 1}}
+	}
+	checked := symbol{documentSymbol{Name: "v", Kind: lsp.SymbolKindVariable, SelectionRange: at(0)}, "file:///p.go", ""}
+	stringer := symbol{documentSymbol{Name: "(T).String", Kind: lsp.SymbolKindMethod, Detail: "func() string", SelectionRange: at(1)}, "file:///p.go", ""}
+	constant := symbol{documentSymbol{Name: "c", Kind: lsp.SymbolKindConstant, SelectionRange: at(2)}, "file:///p.go", ""}
+	r.prefetch(t.Context(), &dirConfig{Kinds: []string{"variable", "method"}}, checked, stringer, constant)
+
+	if _, ok := r.lookups[newNodeKey(checked)]; !ok {
+		t.Fatal("expected references of checked symbol to be queried")
+	}
+	<-r.lookups[newNodeKey(checked)].done
+	if len(r.lookups) != 1 || b.started != 1 {
+		t.Errorf("expected only checked symbol to be queried, got %d lookups and %d queries", len(r.lookups), b.started)
+	}
+}
@@ -0,0 +1,645 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"maps"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+
+	"github.com/rprtr258/punused/internal/lsp"
+)
+
+// _source is name of punused in published diagnostics.
+const _source = "punused"
+
+// server is language server publishing diagnostics for documents opened in editor.
+type server struct {
+	ctx  context.Context
+	opts Options
+
+	writeMu sync.Mutex
+	w       io.Writer
+	// initialized is set once initialize request is handled, shutdown once shutdown request is,
+	// both are accessed by reader only
+	initialized, shutdown bool
+	// resolveEdits is set if editor resolves edits of code actions via codeAction/resolve request,
+	// it is set on initialize, before requests are handled concurrently
+	resolveEdits bool
+	// analyzeCh requests analysis, it is buffered, so that requests made during analysis are merged
+	analyzeCh chan struct{}
+
+	mu sync.Mutex
+	// opened documents, value is set if document has changes which are not saved yet
+	opened map[lsp.URI]bool
+	// saved are documents saved since last analysis
+	saved map[lsp.URI]bool
+	// diagnostics of opened documents found by last analysis
+	diagnostics map[lsp.URI][]diagnostic
+
+	clientMu sync.Mutex
+	// client is backend kept between analyses, nil until first analysis
+	client backend
+}
+
+// Serve runs language server, which speaks LSP over r and w, e.g. stdin and stdout, until client
+// sends exit notification or ctx is canceled. Unused and used in test only symbols of documents
+// opened in editor are published as diagnostics, which are updated once document is saved.
+// Code actions remove unused symbol, unexport symbol or suppress diagnostic with directive.
+// If WorkspaceDir is empty, root of workspace opened in editor is used.
+func Serve(ctx context.Context, opts Options, r io.Reader, w io.Writer) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	s := &server{
+		ctx:          ctx,
+		opts:         opts,
+		writeMu:      sync.Mutex{},
+		w:            w,
+		initialized:  false,
+		shutdown:     false,
+		resolveEdits: false,
+		analyzeCh:    make(chan struct{}, 1),
+		mu:           sync.Mutex{},
+		opened:       map[lsp.URI]bool{},
+		saved:        map[lsp.URI]bool{},
+		diagnostics:  map[lsp.URI][]diagnostic{},
+		clientMu:     sync.Mutex{},
+		client:       nil,
+	}
+
+	analyzed := make(chan struct{})
+	go func() {
+		defer close(analyzed)
+		for range s.analyzeCh {
+			if err := s.analyze(); err != nil {
+				slog.Error("analysis failed", "err", err)
+				_ = s.notify("window/showMessage", lsp.ShowMessageParams{Type: lsp.MTError, Message: "punused: " + err.Error()})
+			}
+		}
+	}()
+	defer func() {
+		close(s.analyzeCh)
+		<-analyzed
+		if s.client != nil {
+			_ = s.client.Close()
+		}
+	}()
+
+	// requests are handled concurrently, they are waited before backend is closed
+	var requests sync.WaitGroup
+	defer requests.Wait()
+
+	msgs, errs := make(chan lsp.Message), make(chan error, 1)
+	go func() {
+		br := bufio.NewReader(r)
+		for {
+			b, err := readMessage(br)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			var msg lsp.Message
+			if err := json.Unmarshal(b, &msg); err != nil {
+				errs <- errors.Wrap(err, "unmarshal")
+				return
+			}
+			select {
+			case msgs <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	handlers := s.handlers()
+	for {
+		var msg lsp.Message
+		select {
+		case msg = <-msgs:
+		case err := <-errs:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+
+		switch {
+		case msg.Method == "exit":
+			if !s.shutdown {
+				return errors.New("exit notification received before shutdown request")
+			}
+			return nil
+		case msg.IsRequest() && s.initialized && msg.Method != "initialize" && msg.Method != "shutdown":
+			// requests not changing state of server are handled off reader, so that slow ones,
+			// e.g. code actions waiting for backend, don't block other messages
+			requests.Go(func() {
+				if err := s.write(respond(handlers, msg)); err != nil {
+					slog.Warn("respond", "method", msg.Method, "err", err)
+				}
+			})
+		case msg.IsRequest():
+			resp := lsp.Response{RPCVersion: "2.0", ID: *msg.ID, Result: nil, Error: &lsp.ResponseError{
+				Code: lsp.ServerNotInitialized, Message: "server is not initialized", Data: nil,
+			}}
+			if s.initialized || msg.Method == "initialize" {
+				resp = respond(handlers, msg)
+			}
+			if err := s.write(resp); err != nil {
+				return errors.Wrapf(err, "respond to %s", msg.Method)
+			}
+		case msg.IsNotification():
+			h, ok := handlers[msg.Method]
+			if !ok || !s.initialized {
+				slog.Debug("unhandled notification", "method", msg.Method)
+				continue
+			}
+			if _, err := h(msg.Params); err != nil {
+				slog.Warn("handle notification", "method", msg.Method, "err", err)
+			}
+		}
+	}
+}
+
+// handlers handle requests and notifications from editor.
+func (s *server) handlers() map[string]handler {
+	// document handles notification about document, which params have textDocument field
+	type documentParams struct {
+		TextDocument lsp.TextDocumentIdentifier `json:"textDocument"`
+	}
+	document := func(f func(uri lsp.URI)) handler {
+		return func(params json.RawMessage) (any, error) {
+			var p documentParams
+			if err := json.Unmarshal(params, &p); err != nil {
+				return nil, err
+			}
+			f(p.TextDocument.URI)
+			return nil, nil
+		}
+	}
+
+	return map[string]handler{
+		"initialize":  s.initialize,
+		"initialized": func(json.RawMessage) (any, error) { return nil, nil },
+		"shutdown": func(json.RawMessage) (any, error) {
+			s.shutdown = true
+			return nil, nil
+		},
+		"textDocument/didOpen": document(func(uri lsp.URI) {
+			s.mu.Lock()
+			s.opened[uri] = false
+			s.mu.Unlock()
+			s.schedule()
+		}),
+		"textDocument/didChange": document(func(uri lsp.URI) {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if _, ok := s.opened[uri]; ok {
+				s.opened[uri] = true
+			}
+		}),
+		"textDocument/didSave": document(func(uri lsp.URI) {
+			s.mu.Lock()
+			s.opened[uri] = false
+			s.saved[uri] = true
+			s.mu.Unlock()
+			s.schedule()
+		}),
+		"textDocument/didClose": document(func(uri lsp.URI) {
+			s.mu.Lock()
+			delete(s.opened, uri)
+			delete(s.diagnostics, uri)
+			s.mu.Unlock()
+			if err := s.publish(uri, nil); err != nil {
+				slog.Warn("clear diagnostics", "uri", uri, "err", err)
+			}
+		}),
+		"textDocument/codeAction": func(params json.RawMessage) (any, error) {
+			var p lsp.CodeActionParams
+			if err := json.Unmarshal(params, &p); err != nil {
+				return nil, err
+			}
+			return s.codeActions(p)
+		},
+		"codeAction/resolve": func(params json.RawMessage) (any, error) {
+			var action lsp.CodeAction
+			if err := json.Unmarshal(params, &action); err != nil {
+				return nil, err
+			}
+			return s.resolveCodeAction(action)
+		},
+	}
+}
+
+func (s *server) initialize(params json.RawMessage) (any, error) {
+	var p lsp.InitializeParams
+	if err := json.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+
+	if s.opts.WorkspaceDir == "" {
+		root := p.RootURI
+		if root == "" && len(p.WorkspaceFolders) > 0 {
+			root = p.WorkspaceFolders[0].URI
+		}
+		s.opts.WorkspaceDir = strings.TrimPrefix(string(root), "file://")
+	}
+	wd, err := filepath.Abs(cmp.Or(s.opts.WorkspaceDir, "."))
+	if err != nil {
+		return nil, errors.Wrap(err, "get workspace directory")
+	}
+	s.opts.WorkspaceDir = wd
+	s.initialized = true
+	if ca := p.Capabilities.TextDocument.CodeAction; ca != nil && ca.ResolveSupport != nil {
+		s.resolveEdits = slices.Contains(ca.ResolveSupport.Properties, "edit")
+	}
+	slog.Info("language server initialized", "workspace", wd)
+
+	return lsp.InitializeResult{
+		Capabilities: lsp.ServerCapabilities{
+			TextDocumentSync: &lsp.TextDocumentSyncOptionsOrKind{
+				Kind: nil,
+				// changes are only tracked to know whether document differs from saved one
+				Options: &lsp.TextDocumentSyncOptions{
+					OpenClose:         true,
+					Change:            lsp.TDSKIncremental,
+					WillSave:          false,
+					WillSaveWaitUntil: false,
+					Save:              &lsp.SaveOptions{IncludeText: false},
+				},
+			},
+			CodeActionProvider: lsp.CodeActionOptions{
+				CodeActionKinds: []lsp.CodeActionKind{lsp.CodeActionQuickFix},
+				ResolveProvider: true,
+			},
+		},
+	}, nil
+}
+
+// schedule requests analysis, unless it is already requested.
+func (s *server) schedule() {
+	select {
+	case s.analyzeCh <- struct{}{}:
+	default:
+	}
+}
+
+// write writes response or notification to editor.
+func (s *server) write(msg any) error {
+	s.writeMu.Lock()
+	defer s.writeMu.Unlock()
+	return writeMessage(s.w, msg)
+}
+
+func (s *server) notify(method string, params any) error {
+	return s.write(lsp.Notification{RPCVersion: "2.0", Method: method, Params: params})
+}
+
+// analyze checks opened documents and publishes their diagnostics. All files to check are
+// still opened in backend, so that references from them are found.
+func (s *server) analyze() error {
+	s.mu.Lock()
+	opened := slices.Sorted(maps.Keys(s.opened))
+	saved := slices.Sorted(maps.Keys(s.saved))
+	clear(s.saved)
+	s.mu.Unlock()
+
+	// config is read again, since it could be changed
+	cfg, err := newRunConfig(s.opts)
+	if err != nil {
+		return err
+	}
+	cfg.logConfigFile()
+
+	client, err := s.backend(saved, cfg)
+	if err != nil {
+		return err
+	}
+	r, err := newRunner(cfg, client)
+	if err != nil {
+		return err
+	}
+
+	files := func(yield func(string, error) bool) {
+		for _, uri := range opened {
+			filename, err := relPath(cfg.WorkspaceDir, uri)
+			if err != nil {
+				yield("", err)
+				return
+			}
+			if strings.HasPrefix(filename, "../") || path.Ext(filename) != ".go" {
+				continue
+			}
+			if excluded, err := r.isFileExcluded(filename); err != nil {
+				yield("", err)
+				return
+			} else if excluded {
+				continue
+			}
+			if !yield(filename, nil) {
+				return
+			}
+		}
+	}
+
+	found := make(map[lsp.URI][]diagnostic, len(opened))
+	for _, uri := range opened {
+		found[uri] = nil
+	}
+	for diag, err := range r.diagnostics(r.symbols(files)) {
+		if err != nil {
+			return err
+		}
+		if diag.Code == CodeUnused || diag.Code == CodeTestOnly {
+			found[diag.Symbol.URI] = append(found[diag.Symbol.URI], diag)
+		}
+	}
+
+	s.mu.Lock()
+	for uri := range found {
+		// documents closed during analysis are not published
+		if _, ok := s.opened[uri]; !ok {
+			delete(found, uri)
+		}
+	}
+	maps.Copy(s.diagnostics, found)
+	s.mu.Unlock()
+
+	for _, uri := range slices.Sorted(maps.Keys(found)) {
+		if err := s.publish(uri, found[uri]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// backend returns backend synced with saved documents, starting it if needed. Lock is
+// held only until backend is ready, so that code actions can use it during analysis.
+func (s *server) backend(saved []lsp.URI, cfg runConfig) (backend, error) {
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	if err := s.sync(saved); err != nil {
+		return nil, err
+	}
+	if s.client == nil {
+		client, err := newBackend(s.ctx, cmp.Or(s.opts.Backend, BackendGopls), cfg.WorkspaceDir, cfg.Concurrency)
+		if err != nil {
+			return nil, err
+		}
+		s.client = client
+	}
+	return s.client, nil
+}
+
+// sync makes backend see saved documents. Backends able to update documents, e.g. gopls,
+// are kept between analyses, others are started again.
+func (s *server) sync(saved []lsp.URI) error {
+	if s.client == nil || len(saved) == 0 {
+		return nil
+	}
+
+	updater, ok := s.client.(renamer)
+	if !ok {
+		err := s.client.Close()
+		s.client = nil
+		return err
+	}
+	for _, uri := range saved {
+		b, err := os.ReadFile(strings.TrimPrefix(string(uri), "file://"))
+		if err != nil {
+			return errors.Wrap(err, "read saved document")
+		}
+		if err := updater.DidChange(uri, string(b)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *server) publish(uri lsp.URI, diags []diagnostic) error {
+	res := make([]lsp.Diagnostic, len(diags))
+	for i, diag := range diags {
+		res[i] = lspDiagnostic(diag)
+	}
+	return s.notify("textDocument/publishDiagnostics", lsp.PublishDiagnosticsParams{URI: uri, Diagnostics: res})
+}
+
+func lspDiagnostic(diag diagnostic) lsp.Diagnostic {
+	severity := lsp.Warning
+	if diag.Severity == SeverityError {
+		severity = lsp.Error
+	}
+	var tags []lsp.DiagnosticTag
+	if diag.Code == CodeUnused {
+		tags = []lsp.DiagnosticTag{lsp.Unnecessary}
+	}
+	return lsp.Diagnostic{
+		Range:    diag.Symbol.SelectionRange,
+		Severity: severity,
+		Code:     string(diag.Code),
+		Source:   _source,
+		Message:  fmt.Sprintf("%s %s is %s", diag.kind(), diag.Symbol.Name, diag.Code.Message()),
+		Tags:     tags,
+	}
+}
+
+// codeActions returns actions fixing diagnostics in range of document: removal of unused
+// symbol, unexporting symbol and suppressing diagnostic. No actions are returned for
+// documents with unsaved changes, since positions of diagnostics can be outdated.
+func (s *server) codeActions(params lsp.CodeActionParams) ([]lsp.CodeAction, error) {
+	uri := params.TextDocument.URI
+	s.mu.Lock()
+	dirty := s.opened[uri]
+	diags := slices.DeleteFunc(slices.Clone(s.diagnostics[uri]), func(diag diagnostic) bool {
+		rng := diag.Symbol.SelectionRange
+		return !rangeContains(rng, params.Range.Start) && !rangeContains(params.Range, rng.Start)
+	})
+	s.mu.Unlock()
+
+	actions := []lsp.CodeAction{}
+	if dirty {
+		return actions, nil
+	}
+
+	for _, diag := range diags {
+		sym := diag.Symbol
+		add := func(title string, edit *lsp.WorkspaceEdit, data json.RawMessage) {
+			actions = append(actions, lsp.CodeAction{
+				Title:       title,
+				Kind:        lsp.CodeActionQuickFix,
+				Diagnostics: []lsp.Diagnostic{lspDiagnostic(diag)},
+				Edit:        edit,
+				Data:        data,
+			})
+		}
+
+		if diag.Code == CodeUnused {
+			edit, err := removeEdit(sym)
+			if err != nil {
+				return nil, err
+			}
+			if edit != nil {
+				add(fmt.Sprintf("Remove unused %s %s", diag.kind(), sym.Name), edit, nil)
+			}
+		}
+
+		if newName, ok := s.canUnexport(sym); ok {
+			title := fmt.Sprintf("Unexport %s %s as %s", diag.kind(), sym.Name, newName)
+			rename := renameData{Location: lsp.Location{URI: sym.URI, Range: sym.SelectionRange}, NewName: newName}
+			if s.resolveEdits {
+				// renaming is slow, so it is done only once action is chosen
+				data, err := json.Marshal(rename)
+				if err != nil {
+					return nil, err
+				}
+				add(title, nil, data)
+			} else if edit, err := s.rename(rename); err == nil {
+				add(title, edit, nil)
+			} else {
+				// e.g. new name conflicts with other symbol or symbol is used by other package
+				slog.Debug("can't unexport", "symbol", sym.QualifiedName(), "name", newName, "err", err)
+			}
+		}
+
+		edit, err := suppressEdit(diag)
+		if err != nil {
+			return nil, err
+		}
+		add(fmt.Sprintf("Suppress %s with %s directive", diag.Code, _directiveIgnore), edit, nil)
+	}
+	return actions, nil
+}
+
+// renameData is data of code action renaming symbol, which edit is resolved lazily.
+type renameData struct {
+	Location lsp.Location `json:"location"`
+	NewName  string       `json:"newName"`
+}
+
+// canUnexport returns name symbol is suggested to be unexported as,
+// false if it is not suggested to unexport or backend can't rename symbols.
+func (s *server) canUnexport(sym symbol) (string, bool) {
+	newName, ok := unexportedName(simpleName(sym))
+	if !ok || !canBeUnexported(sym) {
+		return "", false
+	}
+
+	s.clientMu.Lock()
+	defer s.clientMu.Unlock()
+	_, ok = s.client.(renamer)
+	return newName, ok
+}
+
+// rename returns edit renaming symbol in whole workspace.
+func (s *server) rename(data renameData) (*lsp.WorkspaceEdit, error) {
+	s.clientMu.Lock()
+	rn, ok := s.client.(renamer)
+	s.clientMu.Unlock()
+	if !ok {
+		return nil, errors.New("backend can't rename symbols")
+	}
+
+	edit, err := rn.Rename(data.Location, data.NewName)
+	if err != nil {
+		return nil, err
+	}
+	// versions of documents in backend differ from versions in editor
+	return &lsp.WorkspaceEdit{Changes: edit.Edits(), DocumentChanges: nil}, nil
+}
+
+// resolveCodeAction fills edit of code action returned without it by codeActions.
+func (s *server) resolveCodeAction(action lsp.CodeAction) (lsp.CodeAction, error) {
+	if action.Edit != nil || len(action.Data) == 0 {
+		return action, nil
+	}
+
+	var data renameData
+	if err := json.Unmarshal(action.Data, &data); err != nil {
+		return lsp.CodeAction{}, errors.Wrap(err, "unmarshal code action data")
+	}
+	edit, err := s.rename(data)
+	if err != nil {
+		return lsp.CodeAction{}, errors.Wrapf(err, "rename to %s", data.NewName)
+	}
+	action.Edit = edit
+	return action, nil
+}
+
+// removeEdit returns edit removing symbol together with imports which become unused,
+// nil if symbol can't be removed automatically.
+func removeEdit(s symbol) (*lsp.WorkspaceEdit, error) {
+	filename := strings.TrimPrefix(string(s.URI), "file://")
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrap(err, "read file to fix")
+	}
+
+	res, skipped, err := removeSymbols(filename, src, []symbol{s})
+	if err != nil || len(skipped) > 0 {
+		return nil, err
+	}
+	return &lsp.WorkspaceEdit{Changes: map[lsp.URI][]lsp.TextEdit{s.URI: {textEdit(src, res)}}, DocumentChanges: nil}, nil
+}
+
+// suppressEdit returns edit inserting directive suppressing diagnostic on the line
+// before symbol, so that it is attached to symbol declaration.
+func suppressEdit(diag diagnostic) (*lsp.WorkspaceEdit, error) {
+	uri := diag.Symbol.URI
+	src, err := os.ReadFile(strings.TrimPrefix(string(uri), "file://"))
+	if err != nil {
+		return nil, errors.Wrap(err, "read file to fix")
+	}
+
+	pos := lsp.Position{Line: diag.Symbol.SelectionRange.Start.Line, Character: 0}
+	line := src[offset(src, pos):]
+	indent := line[:len(line)-len(bytes.TrimLeft(line, " \t"))]
+	return &lsp.WorkspaceEdit{Changes: map[lsp.URI][]lsp.TextEdit{uri: {{
+		Range:   lsp.Range{Start: pos, End: pos},
+		NewText: string(indent) + _directiveIgnore + " " + string(diag.Code) + "\n",
+	}}}, DocumentChanges: nil}, nil
+}
+
+// textEdit returns single edit changing src to res, which replaces only changed part of src.
+func textEdit(src, res []byte) lsp.TextEdit {
+	prefix := 0
+	for prefix < len(src) && prefix < len(res) && src[prefix] == res[prefix] {
+		prefix++
+	}
+	// edit must not split runes
+	for prefix > 0 && prefix < len(src) && !utf8.RuneStart(src[prefix]) {
+		prefix--
+	}
+
+	suffix := 0
+	for suffix < len(src)-prefix && suffix < len(res)-prefix && src[len(src)-1-suffix] == res[len(res)-1-suffix] {
+		suffix++
+	}
+	for suffix > 0 && !utf8.RuneStart(src[len(src)-suffix]) {
+		suffix--
+	}
+
+	return lsp.TextEdit{
+		Range:   lsp.Range{Start: position(src, prefix), End: position(src, len(src)-suffix)},
+		NewText: string(res[prefix : len(res)-suffix]),
+	}
+}
+
+// position converts byte offset in src to LSP position, which counts characters in UTF-16 code units.
+func position(src []byte, off int) lsp.Position {
+	line := bytes.Count(src[:off], []byte("\n"))
+	start := bytes.LastIndexByte(src[:off], '\n') + 1
+	return lsp.Position{Line: line, Character: utf16Len(src[start:off])}
+}
@@ -0,0 +1,235 @@
+package engine
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/rprtr258/punused/internal/lsp"
+)
+
+// TestServe checks diagnostics and code actions of language server, talking to it the way editor does.
+func TestServe(t *testing.T) {
+	wd, err := filepath.Abs("..")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	in, toServer := io.Pipe()
+	fromServer, out := io.Pipe()
+	served := make(chan error, 1)
+	go func() {
+		served <- Serve(t.Context(), Options{Patterns: []string{"testdata/**"}}, in, out)
+		out.Close()
+	}()
+
+	r := bufio.NewReader(fromServer)
+	send := func(msg any) {
+		t.Helper()
+		if err := writeMessage(toServer, msg); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+	// receive skips notifications other than published diagnostics
+	receive := func() lsp.Message {
+		t.Helper()
+		for {
+			b, err := readMessage(r)
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			var msg lsp.Message
+			if err := json.Unmarshal(b, &msg); err != nil {
+				t.Fatal(err.Error())
+			}
+			if msg.Method == "window/showMessage" {
+				t.Fatalf("unexpected message: %s", msg.Params)
+			}
+			if msg.IsResponse() || msg.Method == "textDocument/publishDiagnostics" {
+				return msg
+			}
+		}
+	}
+	call := func(id uint64, method string, params, result any) {
+		t.Helper()
+		send(lsp.Request{RPCVersion: "2.0", ID: lsp.ID{Num: id}, Method: method, Params: params})
+		// diagnostics may still be republished by analysis scheduled earlier
+		msg := receive()
+		for !msg.IsResponse() {
+			msg = receive()
+		}
+		if msg.ID.Num != id || msg.Error != nil {
+			t.Fatalf("%s: unexpected response %+v", method, msg)
+		}
+		if err := json.Unmarshal(msg.Result, result); err != nil {
+			t.Fatal(err.Error())
+		}
+	}
+
+	var params lsp.InitializeParams
+	if err := json.Unmarshal([]byte(`{"capabilities":{"textDocument":{"codeAction":{"resolveSupport":{"properties":["edit"]}}}}}`), &params); err != nil {
+		t.Fatal(err.Error())
+	}
+	params.RootURI = documentURI(wd, "")
+	var initialized lsp.InitializeResult
+	call(1, "initialize", params, &initialized)
+	if sync := initialized.Capabilities.TextDocumentSync; sync == nil || sync.Options == nil || sync.Options.Save == nil {
+		t.Fatalf("server must be notified about saved documents, got %+v", initialized.Capabilities)
+	}
+	if provider, ok := initialized.Capabilities.CodeActionProvider.(map[string]any); !ok || provider["resolveProvider"] != true {
+		t.Fatalf("server must resolve code actions, got %+v", initialized.Capabilities.CodeActionProvider)
+	}
+	send(lsp.Notification{RPCVersion: "2.0", Method: "initialized", Params: lsp.InitializedParams{}})
+
+	code1, testlib := documentURI(wd, "testdata/firstpackage/code1.go"), documentURI(wd, "testdata/firstpackage/testlib1.go")
+	for _, uri := range []lsp.URI{code1, testlib} {
+		send(lsp.Notification{RPCVersion: "2.0", Method: "textDocument/didOpen", Params: lsp.DidOpenTextDocumentParams{
+			TextDocument: lsp.TextDocumentItem{URI: uri, LanguageID: "go", Version: 1, Text: ""},
+		}})
+	}
+
+	const golden = `
+testdata/firstpackage/code1.go:7:2 variable UnusedVar is unused (EU1002)
+testdata/firstpackage/code1.go:12:2 constant UnusedConst is unused (EU1002)
+testdata/firstpackage/code1.go:19:6 function UnusedFunction is unused (EU1002)
+testdata/firstpackage/code1.go:25:2 field UnusedField is unused (EU1002)
+testdata/firstpackage/code1.go:32:15 method (MyType).UnusedMethod is unused (EU1002)
+testdata/firstpackage/code1.go:36:6 interface UnusedInterfaceWithUsedAndUnusedMethod is unused (EU1002)
+testdata/firstpackage/code1.go:37:2 method UsedInterfaceMethodReturningInt is unused (EU1002)
+testdata/firstpackage/code1.go:38:2 method UnusedInterfaceMethodReturningInt is unused (EU1002)
+testdata/firstpackage/code1.go:41:6 interface UnusedInterface is unused (EU1002)
+testdata/firstpackage/code1.go:42:2 method UnusedInterfaceReturningInt is unused (EU1002)
+testdata/firstpackage/code1.go:45:6 interface UsedInterface is unused (EU1002)
+testdata/firstpackage/testlib1.go:4:2 constant OnlyUsedInTestConst is used in test only (EU1001)
+`
+	published := map[lsp.URI][]lsp.Diagnostic{}
+	for len(published) < 2 {
+		msg := receive()
+		var params lsp.PublishDiagnosticsParams
+		if err := json.Unmarshal(msg.Params, &params); err != nil {
+			t.Fatal(err.Error())
+		}
+		published[params.URI] = params.Diagnostics
+	}
+	var got strings.Builder
+	for _, uri := range []lsp.URI{code1, testlib} {
+		filename, err := relPath(wd, uri)
+		if err != nil {
+			t.Fatal(err.Error())
+		}
+		for _, diag := range published[uri] {
+			got.WriteString(Finding{
+				Location: Location{Path: filename, Start: Position{diag.Range.Start.Line + 1, diag.Range.Start.Character + 1}},
+				Kind:     strings.Fields(diag.Message)[0],
+				Name:     strings.Fields(diag.Message)[1],
+				Code:     Code(diag.Code),
+			}.String() + "\n")
+		}
+	}
+	if diff := cmp.Diff(strings.TrimSpace(golden), strings.TrimSpace(got.String())); diff != "" {
+		t.Fatal("unexpected diagnostics\n+ actual\n- expected\n" + diff)
+	}
+
+	// UnusedFunction
+	var actions []lsp.CodeAction
+	at := lsp.Position{Line: 18, Character: 8}
+	call(2, "textDocument/codeAction", lsp.CodeActionParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: code1},
+		Range:        lsp.Range{Start: at, End: at},
+		Context:      lsp.CodeActionContext{Diagnostics: nil},
+	}, &actions)
+	titles := make([]string, len(actions))
+	for i, action := range actions {
+		titles[i] = action.Title
+	}
+	if diff := cmp.Diff([]string{
+		"Remove unused function UnusedFunction",
+		"Unexport function UnusedFunction as unusedFunction",
+		"Suppress EU1002 with //punused:ignore directive",
+	}, titles); diff != "" {
+		t.Fatal("unexpected code actions\n" + diff)
+	}
+
+	// edit of unexporting is computed only once action is resolved
+	if actions[1].Edit != nil {
+		t.Fatalf("expected unexport edit to be resolved lazily, got %+v", actions[1].Edit)
+	}
+	call(3, "codeAction/resolve", actions[1], &actions[1])
+
+	src, err := os.ReadFile(filepath.Join(wd, "testdata/firstpackage/code1.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range []string{
+		"func UsedFunction() {\n\tfmt.Println(\"UsedFunction\")\n}\n\ntype MyType struct {",
+		"func unusedFunction() {",
+		"//punused:ignore EU1002\nfunc UnusedFunction() {",
+	} {
+		fixed := applyEdits(src, actions[i].Edit.Changes[code1])
+		if !strings.Contains(string(fixed), want) {
+			t.Errorf("%s: expected fixed file to contain %q, got\n%s", actions[i].Title, want, fixed)
+		}
+	}
+
+	// positions of diagnostics are outdated once document is changed
+	send(lsp.Notification{RPCVersion: "2.0", Method: "textDocument/didChange", Params: lsp.DidChangeTextDocumentParams{
+		TextDocument:   lsp.VersionedTextDocumentIdentifier{TextDocumentIdentifier: lsp.TextDocumentIdentifier{URI: code1}, Version: 2},
+		ContentChanges: []lsp.TextDocumentContentChangeEvent{{Range: &lsp.Range{Start: at, End: at}, RangeLength: 0, Text: "x"}},
+	}})
+	call(4, "textDocument/codeAction", lsp.CodeActionParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: code1},
+		Range:        lsp.Range{Start: at, End: at},
+		Context:      lsp.CodeActionContext{Diagnostics: nil},
+	}, &actions)
+	if len(actions) != 0 {
+		t.Errorf("expected no code actions for changed document, got %+v", actions)
+	}
+
+	send(lsp.Notification{RPCVersion: "2.0", Method: "textDocument/didClose", Params: lsp.DidCloseTextDocumentParams{
+		TextDocument: lsp.TextDocumentIdentifier{URI: code1},
+	}})
+	for {
+		var params lsp.PublishDiagnosticsParams
+		if err := json.Unmarshal(receive().Params, &params); err != nil {
+			t.Fatal(err.Error())
+		}
+		if params.URI == code1 && len(params.Diagnostics) == 0 {
+			break
+		}
+	}
+
+	var null any
+	call(5, "shutdown", nil, &null)
+	send(lsp.Notification{RPCVersion: "2.0", Method: "exit", Params: nil})
+	// analysis still running publishes diagnostics until it is finished
+	go func() { _, _ = io.Copy(io.Discard, r) }()
+	if err := <-served; err != nil {
+		t.Fatal(err.Error())
+	}
+}
+
+func TestTextEdit(t *testing.T) {
+	for _, test := range []struct {
+		src, res string
+		want     lsp.TextEdit
+	}{
+		{"a\nbc\nd", "a\nd", lsp.TextEdit{Range: lsp.Range{Start: lsp.Position{Line: 1, Character: 0}, End: lsp.Position{Line: 2, Character: 0}}, NewText: ""}},
+		{"x := \"ä\"", "x := \"ö\"", lsp.TextEdit{Range: lsp.Range{Start: lsp.Position{Line: 0, Character: 6}, End: lsp.Position{Line: 0, Character: 7}}, NewText: "ö"}},
+		{"𝔸b", "𝔸c", lsp.TextEdit{Range: lsp.Range{Start: lsp.Position{Line: 0, Character: 2}, End: lsp.Position{Line: 0, Character: 3}}, NewText: "c"}},
+		{"same", "same", lsp.TextEdit{Range: lsp.Range{Start: lsp.Position{Line: 0, Character: 4}, End: lsp.Position{Line: 0, Character: 4}}, NewText: ""}},
+	} {
+		got := textEdit([]byte(test.src), []byte(test.res))
+		if got != test.want {
+			t.Errorf("%q -> %q: expected %+v, got %+v", test.src, test.res, test.want, got)
+		}
+		if fixed := applyEdits([]byte(test.src), []lsp.TextEdit{got}); string(fixed) != test.res {
+			t.Errorf("%q -> %q: edit gives %q", test.src, test.res, fixed)
+		}
+	}
+}
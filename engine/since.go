@@ -0,0 +1,312 @@
+package engine
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/rprtr258/punused/internal/lsp"
+)
+
+// revision is workspace at git revision, files changed since which are checked.
+type revision struct {
+	// Changed are Go files added or modified since revision, including untracked ones.
+	Changed map[string]bool
+	// Files are Go files of workspace at revision.
+	Files map[string]bool
+	// Names are identifiers used at revision in files changed or deleted since,
+	// only symbols with such names could lose references.
+	Names map[string]bool
+	// Base is runner in workspace checked out at revision into temporary directory.
+	Base *runner
+}
+
+// git runs git command in dir and returns its output.
+func git(ctx context.Context, dir string, args ...string) ([]byte, error) {
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrapf(err, "git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// changedFiles returns Go files of workspace changed since rev, including untracked ones,
+// and files which are modified or deleted, so that their versions at rev differ.
+func changedFiles(ctx context.Context, wd, rev string) ([]string, []string, error) {
+	// renames are reported as deletion and addition
+	out, err := git(ctx, wd, "diff", "--name-status", "--no-renames", "-z", "--relative", rev, "--", "*.go")
+	if err != nil {
+		return nil, nil, err
+	}
+	var changed, old []string
+	fields := strings.Split(strings.TrimSuffix(string(out), "\x00"), "\x00")
+	for i := 0; i+1 < len(fields); i += 2 {
+		switch status, filename := fields[i], fields[i+1]; status {
+		case "A":
+			changed = append(changed, filename)
+		case "D":
+			old = append(old, filename)
+		default:
+			changed, old = append(changed, filename), append(old, filename)
+		}
+	}
+
+	out, err = git(ctx, wd, "ls-files", "--others", "--exclude-standard", "-z", "--", "*.go")
+	if err != nil {
+		return nil, nil, err
+	}
+	for filename := range strings.SplitSeq(string(out), "\x00") {
+		if filename != "" {
+			changed = append(changed, filename)
+		}
+	}
+	return changed, old, nil
+}
+
+// extract writes regular files of tar archive into dir and returns Go files among them.
+func extract(r io.Reader, dir string) (map[string]bool, error) {
+	files := map[string]bool{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "read archive")
+		}
+		// directories are created for files, symlinks are not followed by go tooling anyway
+		if hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		name := path.Clean(hdr.Name)
+		if !filepath.IsLocal(name) {
+			return nil, errors.Errorf("invalid path %q in archive", hdr.Name)
+		}
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+			return nil, err
+		}
+		f, err := os.Create(filename)
+		if err != nil {
+			return nil, err
+		}
+		_, err = io.Copy(f, tr)
+		if errClose := f.Close(); err == nil {
+			err = errClose
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "extract %s", name)
+		}
+
+		if path.Ext(name) == ".go" {
+			files[name] = true
+		}
+	}
+}
+
+// usedNames returns identifiers used in Go files relative to dir.
+func usedNames(dir string, filenames []string) (map[string]bool, error) {
+	names := map[string]bool{}
+	fset := token.NewFileSet()
+	for _, filename := range filenames {
+		f, err := parser.ParseFile(fset, filepath.Join(dir, filename), nil, parser.SkipObjectResolution)
+		if err != nil {
+			return nil, errors.Wrapf(err, "parse %s at revision", filename)
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			if id, ok := n.(*ast.Ident); ok {
+				names[id.Name] = true
+			}
+			return true
+		})
+	}
+	return names, nil
+}
+
+// newRevision checks out workspace at git revision rev into temporary directory and
+// starts runner there, so that references before changes can be compared with current ones.
+// Revision must be closed after use.
+func newRevision(ctx context.Context, cfg runConfig, kind Backend, concurrency int, rev string) (_ *revision, err error) {
+	changed, old, err := changedFiles(ctx, cfg.WorkspaceDir, rev)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("checking files changed since revision", "revision", rev, "changed", len(changed), "changed or deleted", len(old))
+
+	// workspace can be subdirectory of repository
+	prefix, err := git(ctx, cfg.WorkspaceDir, "rev-parse", "--show-prefix")
+	if err != nil {
+		return nil, err
+	}
+	archive, err := git(ctx, cfg.WorkspaceDir, "archive", "--format=tar", rev+":"+strings.TrimSpace(string(prefix)))
+	if err != nil {
+		return nil, err
+	}
+
+	dir, err := os.MkdirTemp("", "punused-")
+	if err != nil {
+		return nil, errors.Wrap(err, "create directory for revision")
+	}
+	defer func() {
+		if err != nil {
+			_ = os.RemoveAll(dir)
+		}
+	}()
+
+	files, err := extract(bytes.NewReader(archive), dir)
+	if err != nil {
+		return nil, err
+	}
+	names, err := usedNames(dir, old)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := newBackend(ctx, kind, dir, concurrency)
+	if err != nil {
+		return nil, err
+	}
+	baseCfg := cfg
+	baseCfg.WorkspaceDir = dir
+	base, err := newRunner(baseCfg, client)
+	if err != nil {
+		_ = client.Close()
+		return nil, err
+	}
+
+	changedSet := map[string]bool{}
+	for _, filename := range changed {
+		changedSet[filename] = true
+	}
+	return &revision{Changed: changedSet, Files: files, Names: names, Base: base}, nil
+}
+
+func (rev *revision) Close() error {
+	err := rev.Base.Stop()
+	if errRemove := os.RemoveAll(rev.Base.cfg.WorkspaceDir); err == nil {
+		err = errRemove
+	}
+	return err
+}
+
+// changed yields files walked by Walk, which are changed since revision.
+func (r *runner) changed(yield func(string, error) bool) {
+	for filename, err := range r.Walk {
+		if (err != nil || r.since.Changed[filename]) && !yield(filename, err) {
+			return
+		}
+	}
+}
+
+// unchanged yields files walked by Walk, which are the same at revision, and
+// could declare symbols referenced from changed files before changes.
+func (r *runner) unchanged(yield func(string, error) bool) {
+	for filename, err := range r.Walk {
+		if err != nil || !r.since.Changed[filename] && r.since.Files[filename] {
+			if !yield(filename, err) {
+				return
+			}
+		}
+	}
+}
+
+// isMentioned checks whether symbol or any of its children could be referenced from
+// files changed since revision, judging by their names.
+func (rev *revision) isMentioned(s documentSymbol) bool {
+	// methods are named as (T).Method
+	name := s.Name[strings.LastIndex(s.Name, ".")+1:]
+	return rev.Names[name] || slices.ContainsFunc(s.Children, rev.isMentioned)
+}
+
+// wasUsed checks whether symbol, which is unused or used in test only now,
+// was used, or used outside of tests respectively, at revision.
+func (r *runner) wasUsed(diag diagnostic) (bool, error) {
+	filename, err := relPath(r.cfg.WorkspaceDir, diag.Symbol.URI)
+	if err != nil {
+		return false, err
+	}
+	// file is the same at revision, so symbol is at the same position there
+	s := diag.Symbol
+	s.URI = documentURI(r.since.Base.cfg.WorkspaceDir, filename)
+	refs, _, err := r.since.Base.references(s)
+	if err != nil {
+		return false, err
+	}
+
+	if diag.Code == CodeUnused {
+		return len(refs) > 0, nil
+	}
+	return slices.ContainsFunc(refs, func(ref lsp.Location) bool { return !strings.HasSuffix(string(ref.URI), "_test.go") }), nil
+}
+
+// analyzeSince yields diagnostics of symbols in unchanged files, which became unused or used
+// in test only because of changes since revision, then stale suppressions in changed files.
+// Symbols of changed files must be evaluated already.
+func (r *runner) analyzeSince(yield func(diagnostic, error) bool) {
+	mentioned := func(yield func(symbol, error) bool) {
+		for s, err := range r.symbols(r.unchanged) {
+			if (err != nil || r.since.isMentioned(s.documentSymbol)) && !yield(s, err) {
+				return
+			}
+		}
+	}
+	for diag, err := range r.diagnostics(mentioned) {
+		if err != nil {
+			yield(diagnostic{}, err)
+			return
+		}
+		if diag.Code != CodeUnused && diag.Code != CodeTestOnly {
+			continue
+		}
+
+		if used, err := r.wasUsed(diag); err != nil {
+			yield(diagnostic{}, err)
+			return
+		} else if !used {
+			slog.Debug("symbol was not used at revision", "symbol", diag.Symbol.QualifiedName(), "uri", diag.Symbol.URI)
+			continue
+		}
+		if !yield(diag, nil) {
+			return
+		}
+	}
+
+	// unchanged files are not checked entirely and exclusions of config can match symbols of any file
+	stale, err := r.staleSuppressions()
+	if err != nil {
+		yield(diagnostic{}, err)
+		return
+	}
+	for _, diag := range stale {
+		filename, err := relPath(r.cfg.WorkspaceDir, diag.Symbol.URI)
+		if err != nil {
+			yield(diagnostic{}, err)
+			return
+		}
+		if !r.since.Changed[filename] {
+			continue
+		}
+		if !yield(diag, nil) {
+			return
+		}
+	}
+}
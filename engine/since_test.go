@@ -0,0 +1,61 @@
+package engine
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAnalyzeSince(t *testing.T) {
+	wd := t.TempDir()
+	write := func(filename, src string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(wd, filename), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = wd
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, out)
+		}
+	}
+
+	write("go.mod", "module example.com/since\n\ngo 1.25\n")
+	write("main.go", "package main\n\nfunc main() {\n\tused()\n\tremoved()\n}\n")
+	write("lib.go", "package main\n\nfunc used() {}\n\nfunc removed() {}\n\nfunc unusedBefore() {}\n")
+	write("other.go", "package main\n\nfunc unusedOther() {}\n")
+	git("init", "-q")
+	git("add", ".")
+	git("commit", "-q", "-m", "init")
+
+	// last reference to removed is deleted, added.go is not committed yet
+	write("main.go", "package main\n\nfunc main() {\n\tused()\n}\n\nfunc unusedChanged() {}\n")
+	write("added.go", "package main\n\nfunc unusedAdded() {}\n")
+
+	for _, b := range Backends {
+		t.Run(string(b), func(t *testing.T) {
+			var got []string
+			for f, err := range Analyze(t.Context(), Options{WorkspaceDir: wd, Patterns: []string{"*.go"}, Backend: b, Since: "HEAD"}) {
+				if err != nil {
+					t.Fatal(err.Error())
+				}
+				got = append(got, f.String())
+			}
+
+			// unused symbols of unchanged files are reported only if they were used at revision
+			if diff := cmp.Diff([]string{
+				"added.go:3:6 function unusedAdded is unused (EU1002)",
+				"main.go:7:6 function unusedChanged is unused (EU1002)",
+				"lib.go:5:6 function removed is unused (EU1002)",
+			}, got); diff != "" {
+				t.Error("unexpected findings\n" + diff)
+			}
+		})
+	}
+}
@@ -0,0 +1,302 @@
+package engine
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"log/slog"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/rprtr258/punused/internal/lsp"
+)
+
+const (
+	_directiveIgnore     = "//punused:ignore"
+	_directiveFileIgnore = "//punused:file-ignore"
+	_directiveNolint     = "//nolint"
+)
+
+var _reCodes = regexp.MustCompile(`^EU\d{4}(,EU\d{4})*$`)
+
+// directive is a suppression comment found in source file.
+type directive struct {
+	// Pos is position of directive comment itself.
+	Pos lsp.Position
+	// Text is directive comment as written in source.
+	Text string
+	// Codes suppressed by directive, all codes if empty.
+	Codes  []Code
+	Reason string
+	// FirstLine and LastLine are zero-based lines of declaration directive is attached to.
+	// Directive applies to whole file if IsFile is set.
+	FirstLine, LastLine int
+	IsFile              bool
+	// IsExplicit is set if directive is meant for punused, not for all linters as bare //nolint.
+	// Only explicit directives are reported when stale.
+	IsExplicit bool
+	// Matched is set once directive suppresses any diagnostic.
+	Matched bool
+	// Checked is set once symbol directive applies to is analyzed. Directive on
+	// symbol which is not analyzed, e.g. of kind not checked, is never stale.
+	Checked bool
+}
+
+// appliesTo checks whether directive applies to symbol declared at zero-based line.
+func (d directive) appliesTo(line int) bool {
+	return d.IsFile || d.FirstLine <= line && line <= d.LastLine
+}
+
+func (d directive) suppresses(diag diagnostic) bool {
+	return d.appliesTo(diag.Symbol.SelectionRange.Start.Line) &&
+		(len(d.Codes) == 0 || slices.Contains(d.Codes, diag.Code))
+}
+
+// parseDirective parses single comment, returning false if it is not a punused directive.
+// Recognized forms are:
+//
+//	//punused:ignore [EU1001,EU1002] [reason]
+//	//punused:file-ignore [EU1001,EU1002] [reason]
+//	//nolint[:linter1,linter2] [// reason]
+func parseDirective(text string) (directive, bool) {
+	var d directive
+	var rest string
+	switch {
+	case strings.HasPrefix(text, _directiveFileIgnore):
+		d.IsFile = true
+		rest = strings.TrimPrefix(text, _directiveFileIgnore)
+	case strings.HasPrefix(text, _directiveIgnore):
+		rest = strings.TrimPrefix(text, _directiveIgnore)
+	case strings.HasPrefix(text, _directiveNolint):
+		linters, reason, _ := strings.Cut(strings.TrimPrefix(text, _directiveNolint), "//")
+		linters = strings.TrimSpace(linters)
+		if linters != "" {
+			if !strings.HasPrefix(linters, ":") {
+				return directive{}, false
+			}
+			names := strings.Split(strings.TrimPrefix(linters, ":"), ",")
+			if !slices.Contains(names, "punused") && !slices.Contains(names, "all") {
+				return directive{}, false
+			}
+			d.IsExplicit = slices.Contains(names, "punused")
+		}
+		d.Text = text
+		d.Reason = strings.TrimSpace(reason)
+		return d, true
+	default:
+		return directive{}, false
+	}
+
+	if rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		// e.g. //punused:ignored
+		return directive{}, false
+	}
+
+	d.Text = text
+	d.IsExplicit = true
+	d.Reason = strings.TrimSpace(rest)
+	if first, reason, _ := strings.Cut(d.Reason, " "); _reCodes.MatchString(first) {
+		for c := range strings.SplitSeq(first, ",") {
+			d.Codes = append(d.Codes, Code(c))
+		}
+		d.Reason = strings.TrimSpace(reason)
+	}
+	return d, true
+}
+
+// parseDirectives finds all suppression directives in go file.
+func parseDirectives(filename string) ([]directive, error) {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "read %s", filename)
+	}
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments|parser.SkipObjectResolution)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parse %s", filename)
+	}
+
+	// columns are counted in UTF-16 code units, the same way as in symbol ranges
+	position := func(pos token.Pos) lsp.Position {
+		p := fset.Position(pos)
+		lineStart := p.Offset - (p.Column - 1)
+		return lsp.Position{Line: p.Line - 1, Character: utf16Len(src[lineStart:p.Offset])}
+	}
+
+	var res []directive
+	// attach adds directives from comment groups as applying to node.
+	attach := func(node ast.Node, groups ...*ast.CommentGroup) {
+		for _, group := range groups {
+			if group == nil {
+				continue
+			}
+
+			for _, c := range group.List {
+				d, ok := parseDirective(c.Text)
+				if !ok || strings.HasPrefix(c.Text, _directiveFileIgnore) {
+					continue
+				}
+
+				d.Pos = position(c.Pos())
+				if group == f.Doc && strings.HasPrefix(c.Text, _directiveNolint) {
+					// nolint before package clause applies to whole file, same as in golangci-lint
+					d.IsFile = true
+				}
+				if !d.IsFile {
+					d.FirstLine = position(node.Pos()).Line
+					d.LastLine = position(node.End()).Line
+				}
+				res = append(res, d)
+			}
+		}
+	}
+
+	attach(f, f.Doc)
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n := n.(type) {
+		case *ast.GenDecl:
+			attach(n, n.Doc)
+		case *ast.FuncDecl:
+			attach(n, n.Doc)
+		case *ast.TypeSpec:
+			attach(n, n.Doc, n.Comment)
+		case *ast.ValueSpec:
+			attach(n, n.Doc, n.Comment)
+		case *ast.Field:
+			attach(n, n.Doc, n.Comment)
+		}
+		return true
+	})
+
+	// file level directives may be placed anywhere, not only in doc comments
+	for _, group := range f.Comments {
+		for _, c := range group.List {
+			if d, ok := parseDirective(c.Text); ok && strings.HasPrefix(c.Text, _directiveFileIgnore) {
+				d.Pos = position(c.Pos())
+				res = append(res, d)
+			}
+		}
+	}
+
+	return res, nil
+}
+
+// isSuppressed checks whether diagnostic is suppressed by directive in its file
+// or by ExcludedSymbols config entry. All matching suppressions are marked as matched.
+func (f *Filter) isSuppressed(cfg *dirConfig, diag diagnostic) bool {
+	suppressed := false
+	directives := f.directives[diag.Symbol.URI]
+	for i, d := range directives {
+		if d.suppresses(diag) {
+			slog.Debug("diagnostic suppressed", "symbol", diag.Symbol.QualifiedName(), "code", diag.Code, "rule", d.Text)
+			directives[i].Matched = true
+			suppressed = true
+		}
+	}
+
+	for _, excluded := range cfg.ExcludedSymbols {
+		if excluded.matches(diag.Symbol) {
+			slog.Debug("diagnostic suppressed", "symbol", diag.Symbol.QualifiedName(), "code", diag.Code,
+				"rule", "exclude.symbols "+excluded.Name, "config", excluded.ConfigFile)
+			f.matchedExclusions[excluded] = true
+			suppressed = true
+		}
+	}
+
+	return suppressed
+}
+
+// markChecked marks directives which apply to symbol as checked, since symbol is analyzed.
+func (f *Filter) markChecked(s symbol) {
+	directives := f.directives[s.URI]
+	for i, d := range directives {
+		if d.appliesTo(s.SelectionRange.Start.Line) {
+			directives[i].Checked = true
+		}
+	}
+}
+
+// markSkipped marks exclusions of symbol and its children, which are not analyzed, as skipped.
+func (f *Filter) markSkipped(cfg *dirConfig, s symbol) {
+	for _, excluded := range cfg.ExcludedSymbols {
+		if excluded.matches(s) {
+			f.skippedExclusions[excluded] = true
+		}
+	}
+	for _, ch := range s.Children {
+		f.markSkipped(cfg, symbol{ch, s.URI, s.QualifiedName()})
+	}
+}
+
+// excludesFilesIn checks whether any file in dir relative to workspace or its subdirectories is left out of analysis.
+func (f *Filter) excludesFilesIn(dir string) bool {
+	for excluded := range f.excludedDirs {
+		if dir == "." || excluded == dir || strings.HasPrefix(excluded, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// staleSuppressions returns diagnostics for suppressions which did not suppress anything.
+// Must be called only after all diagnostics are evaluated.
+func (f *Filter) staleSuppressions() ([]diagnostic, error) {
+	var res []diagnostic
+	for _, uri := range slices.Sorted(maps.Keys(f.directives)) {
+		cfg, err := f.configForURI(uri)
+		if err != nil {
+			return nil, err
+		}
+		sev := cfg.Severities[CodeStaleSuppression]
+		if sev == SeverityOff {
+			continue
+		}
+
+		for _, d := range f.directives[uri] {
+			if d.Matched || !d.Checked || !d.IsExplicit {
+				continue
+			}
+
+			// directives are line comments, so they end on the same line
+			rng := lsp.Range{Start: d.Pos, End: lsp.Position{Line: d.Pos.Line, Character: d.Pos.Character + utf16Len([]byte(d.Text))}}
+			res = append(res, diagnostic{
+				Symbol: symbol{
+					documentSymbol: documentSymbol{
+						Name:           d.Text,
+						Range:          rng,
+						SelectionRange: rng,
+					},
+					URI: uri,
+				},
+				Code:     CodeStaleSuppression,
+				Severity: sev,
+			})
+		}
+	}
+
+	for _, excluded := range f.exclusions {
+		sev := f.configs[excluded.Dir].Severities[CodeStaleSuppression]
+		// symbol of skipped file can't be told from deleted one
+		if f.matchedExclusions[excluded] || f.skippedExclusions[excluded] || f.cfg.SkipTests || f.excludesFilesIn(excluded.Dir) || sev == SeverityOff {
+			continue
+		}
+
+		rng := lsp.Range{Start: excluded.Pos, End: lsp.Position{Line: excluded.Pos.Line, Character: excluded.Pos.Character + utf16Len([]byte(excluded.Name))}}
+		res = append(res, diagnostic{
+			Symbol: symbol{
+				documentSymbol: documentSymbol{Name: excluded.Name, Range: rng, SelectionRange: rng},
+				URI:            lsp.URI("file://" + filepath.ToSlash(excluded.ConfigFile)),
+			},
+			Code:     CodeStaleSuppression,
+			Severity: sev,
+		})
+	}
+
+	return res, nil
+}
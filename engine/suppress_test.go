@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParseDirective(t *testing.T) {
+	for _, test := range []struct {
+		text string
+		want directive
+		ok   bool
+	}{
+		{"//punused:ignore", directive{Text: "//punused:ignore", IsExplicit: true}, true},
+		{"//punused:ignore EU1002 not yet used", directive{Text: "//punused:ignore EU1002 not yet used", Codes: []Code{CodeUnused}, Reason: "not yet used", IsExplicit: true}, true},
+		{"//punused:ignore EU1001,EU1002", directive{Text: "//punused:ignore EU1001,EU1002", Codes: []Code{CodeTestOnly, CodeUnused}, IsExplicit: true}, true},
+		{"//punused:ignore kept for compatibility", directive{Text: "//punused:ignore kept for compatibility", Reason: "kept for compatibility", IsExplicit: true}, true},
+		{"//punused:file-ignore generated", directive{Text: "//punused:file-ignore generated", Reason: "generated", IsFile: true, IsExplicit: true}, true},
+		{"//nolint", directive{Text: "//nolint"}, true},
+		{"//nolint:all", directive{Text: "//nolint:all"}, true},
+		{"//nolint:errcheck,punused // reason", directive{Text: "//nolint:errcheck,punused // reason", Reason: "reason", IsExplicit: true}, true},
+		{"//nolint:errcheck", directive{}, false},
+		{"//nolintlint", directive{}, false},
+		{"//punused:ignored", directive{}, false},
+		{"// punused:ignore", directive{}, false},
+		{"// just a comment", directive{}, false},
+	} {
+		got, ok := parseDirective(test.text)
+		if ok != test.ok {
+			t.Errorf("%q: expected ok=%t, got %t", test.text, test.ok, ok)
+			continue
+		}
+		if diff := cmp.Diff(test.want, got); diff != "" {
+			t.Errorf("%q: unexpected directive\n+ actual\n- expected\n%s", test.text, diff)
+		}
+	}
+}
+
+func TestStaleSuppressions(t *testing.T) {
+	wd := t.TempDir()
+	for filename, src := range map[string]string{
+		"go.mod":        "module example.com/stale\n\ngo 1.24\n",
+		".punused.yaml": "kinds: [function, constant]\nexclude:\n  symbols:\n    - Ignored\n    - Deleted\n",
+		"other.go":      "package main\n",
+		"main.go": `package main
+
+func main() { _ = cafés }
+
+//punused:ignore types are not checked
+type Ignored struct{}
+
+const cafés = "é" //punused:ignore used
+`,
+	} {
+		if err := os.WriteFile(filepath.Join(wd, filename), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stale := func(patterns ...string) []string {
+		var res []string
+		for f, err := range Analyze(t.Context(), Options{WorkspaceDir: wd, Patterns: patterns, Backend: BackendPackages}) {
+			if err != nil {
+				t.Fatal(err.Error())
+			}
+			res = append(res, fmt.Sprintf("%s %d:%d-%d:%d", f, f.Location.Start.Line, f.Location.Start.Column, f.Location.End.Line, f.Location.End.Column))
+		}
+		return res
+	}
+
+	// suppressions of struct, which is not checked, are not stale, unlike exclusion of deleted symbol,
+	// columns are counted in UTF-16 code units
+	if diff := cmp.Diff([]string{
+		"main.go:8:19 suppression //punused:ignore used is stale (EU1003) 8:19-8:40",
+		".punused.yaml:5:7 suppression Deleted is stale (EU1003) 5:7-5:14",
+	}, stale("*.go")); diff != "" {
+		t.Error("unexpected findings\n" + diff)
+	}
+
+	// exclusion can apply to symbol of file not matched by patterns
+	if diff := cmp.Diff([]string{
+		"main.go:8:19 suppression //punused:ignore used is stale (EU1003) 8:19-8:40",
+	}, stale("main.go")); diff != "" {
+		t.Error("unexpected findings with narrowed patterns\n" + diff)
+	}
+}
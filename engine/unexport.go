@@ -0,0 +1,109 @@
+package engine
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+
+	"github.com/rprtr258/punused/internal/lsp"
+)
+
+// simpleName returns name of symbol without receiver, e.g. MyMethod for (*MyType).MyMethod.
+func simpleName(s symbol) string {
+	if s.Kind == lsp.SymbolKindMethod {
+		if _, method, ok := strings.Cut(s.Name, "."); ok {
+			return method
+		}
+	}
+	return s.Name
+}
+
+// unexportedName returns name with leading upper case letters lowered,
+// keeping initialisms readable: HTTPClient becomes httpClient, ID becomes id and URLs becomes urls.
+// Returns false if name is not exported or unexported name can't be used, e.g.
+// because it is a keyword or shadows predeclared identifier.
+func unexportedName(name string) (string, bool) {
+	runes := []rune(name)
+	if len(runes) == 0 || !unicode.IsUpper(runes[0]) {
+		return "", false
+	}
+
+	i := 0
+	for i < len(runes) && unicode.IsUpper(runes[i]) {
+		i++
+	}
+	plural := i > 1 && i < len(runes) && runes[i] == 's' && (i+1 == len(runes) || !unicode.IsLower(runes[i+1]))
+	if i > 1 && i < len(runes) && unicode.IsLetter(runes[i]) && !plural {
+		// last upper letter starts next word, as C in HTTPClient, unless initialism is plural, as in IDsByName
+		i--
+	}
+	for j := range i {
+		runes[j] = unicode.ToLower(runes[j])
+	}
+
+	res := string(runes)
+	if token.IsKeyword(res) || types.Universe.Lookup(res) != nil {
+		return "", false
+	}
+	return res, true
+}
+
+// packageName returns name of package go file belongs to.
+func (r *runner) packageName(uri lsp.URI) (string, error) {
+	if name, ok := r.packageNames[uri]; ok {
+		return name, nil
+	}
+
+	f, err := parser.ParseFile(token.NewFileSet(), strings.TrimPrefix(string(uri), "file://"), nil, parser.PackageClauseOnly)
+	if err != nil {
+		return "", errors.Wrap(err, "parse package clause")
+	}
+	r.packageNames[uri] = f.Name.Name
+	return f.Name.Name, nil
+}
+
+// isUsedInOwnPackageOnly checks whether all references to symbol are in the package it is declared in.
+// External test packages in the same directory are considered different packages.
+func (r *runner) isUsedInOwnPackageOnly(s symbol, refs []lsp.Location) (bool, error) {
+	pkg, err := r.packageName(s.URI)
+	if err != nil {
+		return false, err
+	}
+
+	dir := path.Dir(string(s.URI))
+	for _, ref := range refs {
+		if path.Dir(string(ref.URI)) != dir {
+			return false, nil
+		}
+
+		refPkg, err := r.packageName(ref.URI)
+		if err != nil {
+			return false, err
+		}
+		if refPkg != pkg {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// canBeUnexported checks whether symbol is kind of symbol punused suggests to unexport.
+// Fields and interface methods are not suggested, since they are commonly
+// required to be exported by encoding packages and interface implementations.
+func canBeUnexported(s symbol) bool {
+	switch s.Kind {
+	case lsp.SymbolKindFunction, lsp.SymbolKindMethod,
+		lsp.SymbolKindVariable, lsp.SymbolKindConstant,
+		lsp.SymbolKindStruct, lsp.SymbolKindInterface, lsp.SymbolKindClass:
+	default:
+		return false
+	}
+
+	return s.Parent == "" && ast.IsExported(simpleName(s))
+}
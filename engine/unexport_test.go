@@ -0,0 +1,30 @@
+package engine
+
+import "testing"
+
+func TestUnexportedName(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		want string
+		ok   bool
+	}{
+		{"UsedFunction", "usedFunction", true},
+		{"HTTPClient", "httpClient", true},
+		{"ID", "id", true},
+		{"URLs", "urls", true},
+		{"IDs", "ids", true},
+		{"IDsByName", "idsByName", true},
+		{"APIServer", "apiServer", true},
+		{"X", "x", true},
+		{"usedFunction", "", false},
+		{"Func", "", false},
+		{"String", "", false},
+		{"Len", "", false},
+		{"", "", false},
+	} {
+		got, ok := unexportedName(test.name)
+		if ok != test.ok || ok && got != test.want {
+			t.Errorf("%q: expected %q, %t, got %q, %t", test.name, test.want, test.ok, got, ok)
+		}
+	}
+}
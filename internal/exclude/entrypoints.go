@@ -0,0 +1,137 @@
+package exclude
+
+import (
+	"go/ast"
+	"go/types"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// testEntrypoint is a kind of function in _test.go file which is called by go test.
+type testEntrypoint struct {
+	Prefix string
+	// Param is name of type in testing package of the only parameter, empty if there are no parameters.
+	Param string
+}
+
+var _testEntrypoints = []testEntrypoint{
+	{"Test", "T"},
+	{"Benchmark", "B"},
+	{"Fuzz", "F"},
+	{"Example", ""},
+}
+
+// IsEntrypoint checks whether function declared in file is called by go toolchain, that is init,
+// main of main package, or test, benchmark, fuzz target or example in _test.go file.
+// If sig, type of function, is known, parameter type is resolved through it, so that aliases
+// of testing types are recognized, otherwise it is matched syntactically.
+func IsEntrypoint(filename string, f *ast.File, fn *ast.FuncDecl, sig *types.Signature) bool {
+	if fn.Recv != nil || fn.Type.TypeParams != nil || fn.Type.Results != nil && len(fn.Type.Results.List) > 0 {
+		return false
+	}
+
+	params := fn.Type.Params.List
+	switch {
+	case fn.Name.Name == "init":
+		return len(params) == 0
+	case fn.Name.Name == "main":
+		return len(params) == 0 && f.Name.Name == "main"
+	case !strings.HasSuffix(filename, "_test.go"):
+		return false
+	case fn.Name.Name == "TestMain":
+		return len(params) == 1 && len(params[0].Names) <= 1 && isTestingParam(f, params[0].Type, sig, "M")
+	}
+
+	for _, e := range _testEntrypoints {
+		if !isTestName(fn.Name.Name, e.Prefix) {
+			continue
+		}
+
+		if e.Param == "" {
+			return len(params) == 0
+		}
+		return len(params) == 1 && len(params[0].Names) <= 1 && isTestingParam(f, params[0].Type, sig, e.Param)
+	}
+	return false
+}
+
+// isTestName checks whether name is prefix followed by nothing or by not lower case letter,
+// as go test does, so that Testable is not a test.
+func isTestName(name, prefix string) bool {
+	if !strings.HasPrefix(name, prefix) {
+		return false
+	}
+	if len(name) == len(prefix) {
+		return true
+	}
+	r, _ := utf8.DecodeRuneInString(name[len(prefix):])
+	return !unicode.IsLower(r)
+}
+
+// isTestingParam checks whether the only parameter of function, given by its type expression
+// and, if known, function signature, is pointer to type of testing package with given name.
+func isTestingParam(f *ast.File, expr ast.Expr, sig *types.Signature, name string) bool {
+	if sig == nil {
+		return isTestingType(f, expr, name)
+	}
+	return sig.Params().Len() == 1 && isResolvedTestingType(sig.Params().At(0).Type(), name)
+}
+
+// isResolvedTestingType checks whether typ is pointer to type of testing package with given name,
+// looking through aliases and types defined as such pointer or type, e.g. type T testing.T.
+func isResolvedTestingType(typ types.Type, name string) bool {
+	ptr, ok := typ.Underlying().(*types.Pointer)
+	if !ok {
+		return false
+	}
+	named, ok := types.Unalias(ptr.Elem()).(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return false
+	}
+	if named.Obj().Pkg().Path() == "testing" {
+		return named.Obj().Name() == name
+	}
+
+	for _, imp := range named.Obj().Pkg().Imports() {
+		if imp.Path() != "testing" {
+			continue
+		}
+		obj, ok := imp.Scope().Lookup(name).(*types.TypeName)
+		return ok && types.Identical(named.Underlying(), obj.Type().Underlying())
+	}
+	return false
+}
+
+// isTestingType checks whether expr is pointer to type of testing package
+// with given name, resolving import name of testing package in file.
+func isTestingType(f *ast.File, expr ast.Expr, name string) bool {
+	star, ok := expr.(*ast.StarExpr)
+	if !ok {
+		return false
+	}
+
+	for _, imp := range f.Imports {
+		if path, err := strconv.Unquote(imp.Path.Value); err != nil || path != "testing" {
+			continue
+		}
+
+		pkg := "testing"
+		if imp.Name != nil {
+			pkg = imp.Name.Name
+		}
+
+		switch x := star.X.(type) {
+		case *ast.SelectorExpr:
+			if id, ok := x.X.(*ast.Ident); ok && id.Name == pkg && x.Sel.Name == name {
+				return true
+			}
+		case *ast.Ident:
+			if pkg == "." && x.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package exclude
+
+import (
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"testing"
+)
+
+func TestIsEntrypointResolvesTypes(t *testing.T) {
+	const src = `package p
+
+import "testing"
+
+type (
+	T       = testing.T
+	Defined testing.T
+	PtrB    = *testing.B
+	Other   struct{}
+)
+
+func TestAlias(t *T) {}
+
+func TestDefined(t *Defined) {}
+
+func BenchmarkPtrAlias(b PtrB) {}
+
+func TestOther(t *Other) {}
+
+func TestBenchmarkAlias(b PtrB) {}
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "p_test.go", src, parser.SkipObjectResolution)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info := &types.Info{Defs: map[*ast.Ident]types.Object{}}
+	if _, err := (&types.Config{Importer: importer.ForCompiler(fset, "source", nil)}).Check("p", fset, []*ast.File{f}, info); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]bool{
+		"TestAlias":          true,
+		"TestDefined":        true,
+		"BenchmarkPtrAlias":  true,
+		"TestOther":          false,
+		"TestBenchmarkAlias": false,
+	}
+	for _, decl := range f.Decls {
+		fn, ok := decl.(*ast.FuncDecl)
+		if !ok {
+			continue
+		}
+
+		sig := info.Defs[fn.Name].Type().(*types.Signature)
+		if got := IsEntrypoint("p_test.go", f, fn, sig); got != want[fn.Name.Name] {
+			t.Errorf("%s: expected %t, got %t", fn.Name.Name, want[fn.Name.Name], got)
+		}
+		// without types only selector of testing package is recognized
+		if IsEntrypoint("p_test.go", f, fn, nil) {
+			t.Errorf("%s: expected not to be recognized without types", fn.Name.Name)
+		}
+	}
+}
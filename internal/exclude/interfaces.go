@@ -0,0 +1,163 @@
+// Package exclude decides which symbols are used implicitly and so are never
+// reported: functions called by Go toolchain and methods of well-known interfaces.
+package exclude
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"strings"
+
+	"github.com/pkg/errors"
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// Method is a method of well-known interface. Methods implementing
+// such interfaces are usually called by reflection or through interface
+// in other module, so they are not reported.
+type Method struct {
+	// Interface is name of interface method belongs to, for documentation only.
+	Interface string
+	Name      string
+	// Signature is normalized method signature, see NormalizeSignature.
+	Signature string
+}
+
+// _wellKnownMethods is catalog of methods of well-known standard library and popular module interfaces.
+var _wellKnownMethods = []Method{
+	{"error", "Error", "func() string"},
+	{"errors.Unwrap", "Unwrap", "func() error"},
+	{"errors.Join", "Unwrap", "func() []error"},
+	{"errors.Is", "Is", "func(error) bool"},
+	{"errors.As", "As", "func(interface{}) bool"},
+
+	{"fmt.Stringer", "String", "func() string"},
+	{"fmt.GoStringer", "GoString", "func() string"},
+	{"fmt.Formatter", "Format", "func(fmt.State, rune)"},
+
+	{"encoding.TextMarshaler", "MarshalText", "func() ([]byte, error)"},
+	{"encoding.TextUnmarshaler", "UnmarshalText", "func([]byte) error"},
+	{"encoding.BinaryMarshaler", "MarshalBinary", "func() ([]byte, error)"},
+	{"encoding.BinaryUnmarshaler", "UnmarshalBinary", "func([]byte) error"},
+	{"json.Marshaler", "MarshalJSON", "func() ([]byte, error)"},
+	{"json.Unmarshaler", "UnmarshalJSON", "func([]byte) error"},
+	{"xml.Marshaler", "MarshalXML", "func(*xml.Encoder, xml.StartElement) error"},
+	{"xml.Unmarshaler", "UnmarshalXML", "func(*xml.Decoder, xml.StartElement) error"},
+	{"xml.MarshalerAttr", "MarshalXMLAttr", "func(xml.Name) (xml.Attr, error)"},
+	{"xml.UnmarshalerAttr", "UnmarshalXMLAttr", "func(xml.Attr) error"},
+	{"yaml.Marshaler", "MarshalYAML", "func() (interface{}, error)"},
+	{"yaml.v3.Unmarshaler", "UnmarshalYAML", "func(*yaml.Node) error"},
+	{"yaml.v2.Unmarshaler", "UnmarshalYAML", "func(func(interface{}) error) error"},
+	{"gob.GobEncoder", "GobEncode", "func() ([]byte, error)"},
+	{"gob.GobDecoder", "GobDecode", "func([]byte) error"},
+
+	{"sql.Scanner", "Scan", "func(interface{}) error"},
+	{"driver.Valuer", "Value", "func() (driver.Value, error)"},
+
+	{"io.Reader", "Read", "func([]byte) (int, error)"},
+	{"io.Writer", "Write", "func([]byte) (int, error)"},
+	{"io.Closer", "Close", "func() error"},
+	{"io.Seeker", "Seek", "func(int64, int) (int64, error)"},
+	{"io.ReaderAt", "ReadAt", "func([]byte, int64) (int, error)"},
+	{"io.WriterAt", "WriteAt", "func([]byte, int64) (int, error)"},
+	{"io.ReaderFrom", "ReadFrom", "func(io.Reader) (int64, error)"},
+	{"io.WriterTo", "WriteTo", "func(io.Writer) (int64, error)"},
+	{"io.ByteReader", "ReadByte", "func() (byte, error)"},
+	{"io.ByteWriter", "WriteByte", "func(byte) error"},
+	{"io.StringWriter", "WriteString", "func(string) (int, error)"},
+
+	{"http.Handler", "ServeHTTP", "func(http.ResponseWriter, *http.Request)"},
+	{"http.RoundTripper", "RoundTrip", "func(*http.Request) (*http.Response, error)"},
+
+	{"sort.Interface", "Len", "func() int"},
+	{"sort.Interface", "Less", "func(int, int) bool"},
+	{"sort.Interface", "Swap", "func(int, int)"},
+	{"heap.Interface", "Push", "func(interface{})"},
+	{"heap.Interface", "Pop", "func() interface{}"},
+
+	{"flag.Value", "String", "func() string"},
+	{"flag.Value", "Set", "func(string) error"},
+	{"flag.Getter", "Get", "func() interface{}"},
+
+	{"proto.Message", "ProtoReflect", "func() protoreflect.Message"},
+	{"protoiface.MessageV1", "Reset", "func()"},
+	{"protoiface.MessageV1", "ProtoMessage", "func()"},
+}
+
+// NormalizeSignature returns function signature without parameter and result names,
+// with any replaced by interface{}, e.g. func(p []byte) (n int, err error) becomes func([]byte) (int, error).
+func NormalizeSignature(sig string) (string, error) {
+	expr, err := parser.ParseExpr(sig)
+	if err != nil {
+		return "", errors.Wrapf(err, "parse signature %q", sig)
+	}
+	fn, ok := expr.(*ast.FuncType)
+	if !ok {
+		return "", errors.Errorf("signature %q is not a function type", sig)
+	}
+
+	fn = astutil.Apply(fn, func(c *astutil.Cursor) bool {
+		if id, ok := c.Node().(*ast.Ident); ok && id.Name == "any" {
+			c.Replace(&ast.InterfaceType{Methods: &ast.FieldList{}})
+		}
+		return true
+	}, nil).(*ast.FuncType)
+
+	fieldTypes := func(fields *ast.FieldList) []string {
+		var res []string
+		for _, field := range fields.List {
+			typ := types.ExprString(field.Type)
+			for range max(1, len(field.Names)) {
+				res = append(res, typ)
+			}
+		}
+		return res
+	}
+
+	res := "func(" + strings.Join(fieldTypes(fn.Params), ", ") + ")"
+	if fn.Results != nil {
+		switch results := fieldTypes(fn.Results); len(results) {
+		case 0:
+		case 1:
+			res += " " + results[0]
+		default:
+			res += " (" + strings.Join(results, ", ") + ")"
+		}
+	}
+	return res, nil
+}
+
+// ParseMethod parses method of interface declared in config, like Init(ctx context.Context) error.
+func ParseMethod(iface, method string) (Method, error) {
+	name, params, ok := strings.Cut(method, "(")
+	name = strings.TrimSpace(name)
+	if !ok || !token.IsIdentifier(name) {
+		return Method{}, errors.Errorf("invalid method %q, expected method like Name(params) results", method)
+	}
+
+	sig, err := NormalizeSignature("func(" + params)
+	if err != nil {
+		return Method{}, err
+	}
+	return Method{iface, name, sig}, nil
+}
+
+// IsWellKnownMethod checks whether method with given name and signature, like gopls
+// detail func(p []byte) (n int, err error), implements method of well-known interface
+// or of one of interfaces declared by user.
+func IsWellKnownMethod(name, signature string, interfaces []Method) bool {
+	sig, err := NormalizeSignature(signature)
+	if err != nil {
+		return false
+	}
+
+	for _, methods := range [][]Method{_wellKnownMethods, interfaces} {
+		for _, m := range methods {
+			if m.Name == name && m.Signature == sig {
+				return true
+			}
+		}
+	}
+	return false
+}
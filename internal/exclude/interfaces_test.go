@@ -0,0 +1,58 @@
+package exclude
+
+import "testing"
+
+func TestNormalizeSignature(t *testing.T) {
+	for sig, want := range map[string]string{
+		"func() string":                                     "func() string",
+		"func(p []byte) (n int, err error)":                 "func([]byte) (int, error)",
+		"func(a, b int) bool":                               "func(int, int) bool",
+		"func(src any) error":                               "func(interface{}) error",
+		"func(w http.ResponseWriter, r *http.Request)":      "func(http.ResponseWriter, *http.Request)",
+		"func(unmarshal func(interface{}) error) (e error)": "func(func(interface{}) error) error",
+		"func(args ...string)":                              "func(...string)",
+	} {
+		got, err := NormalizeSignature(sig)
+		if err != nil {
+			t.Errorf("%q: %v", sig, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("%q: expected %q, got %q", sig, want, got)
+		}
+	}
+
+	if _, err := NormalizeSignature("int"); err == nil {
+		t.Error("expected error for non function signature")
+	}
+}
+
+func TestIsWellKnownMethod(t *testing.T) {
+	m, err := ParseMethod("plugin.Plugin", "Init(ctx context.Context, opts ...Option) error")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	interfaces := []Method{m}
+
+	for _, test := range []struct {
+		name, signature string
+		want            bool
+	}{
+		{"String", "func() string", true},
+		{"MarshalJSON", "func() ([]byte, error)", true},
+		{"Write", "func(b []byte) (written int, err error)", true},
+		{"Scan", "func(value any) error", true},
+		{"String", "func(x int) string", false},
+		{"Stringify", "func() string", false},
+		{"Init", "func(c context.Context, o ...Option) error", true},
+		{"Init", "func(c context.Context) error", false},
+	} {
+		if got := IsWellKnownMethod(test.name, test.signature, interfaces); got != test.want {
+			t.Errorf("%s %s: expected %t, got %t", test.name, test.signature, test.want, got)
+		}
+	}
+
+	if _, err := ParseMethod("a.B", "Init"); err == nil {
+		t.Error("expected error for method without signature")
+	}
+}
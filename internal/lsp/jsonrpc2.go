@@ -1,7 +1,11 @@
 // https://microsoft.github.io/language-server-protocol/specifications/base/0.9/specification/
 package lsp
 
-import "encoding/json"
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
 
 // ID represents a JSON-RPC 2.0 request ID, which may be either a
 // string or number (or null, which is unsupported).
@@ -18,12 +22,12 @@ type ID struct {
 	IsString bool
 }
 
-// func (id ID) String() string {
-// 	if id.IsString {
-// 		return strconv.Quote(id.Str)
-// 	}
-// 	return strconv.FormatUint(id.Num, 10)
-// }
+func (id ID) String() string {
+	if id.IsString {
+		return strconv.Quote(id.Str)
+	}
+	return strconv.FormatUint(id.Num, 10)
+}
 
 // MarshalJSON implements json.Marshaler.
 func (id ID) MarshalJSON() ([]byte, error) {
@@ -33,21 +37,21 @@ func (id ID) MarshalJSON() ([]byte, error) {
 	return json.Marshal(id.Num)
 }
 
-// // UnmarshalJSON implements json.Unmarshaler.
-// func (id *ID) UnmarshalJSON(data []byte) error {
-// 	// Support both uint64 and string IDs.
-// 	var v uint64
-// 	if err := json.Unmarshal(data, &v); err == nil {
-// 		*id = ID{Num: v}
-// 		return nil
-// 	}
-// 	var v2 string
-// 	if err := json.Unmarshal(data, &v2); err != nil {
-// 		return err
-// 	}
-// 	*id = ID{Str: v2, IsString: true}
-// 	return nil
-// }
+// UnmarshalJSON implements json.Unmarshaler.
+func (id *ID) UnmarshalJSON(data []byte) error {
+	// Support both uint64 and string IDs.
+	var v uint64
+	if err := json.Unmarshal(data, &v); err == nil {
+		*id = ID{Num: v}
+		return nil
+	}
+	var v2 string
+	if err := json.Unmarshal(data, &v2); err != nil {
+		return err
+	}
+	*id = ID{Str: v2, IsString: true}
+	return nil
+}
 
 type Request struct {
 	RPCVersion string `json:"jsonrpc"`
@@ -56,6 +60,13 @@ type Request struct {
 	Params     any    `json:"params"`
 }
 
+// Notification is a request which is not responded to.
+type Notification struct {
+	RPCVersion string `json:"jsonrpc"`
+	Method     string `json:"method"`
+	Params     any    `json:"params"`
+}
+
 type ErrorCode int
 
 const (
@@ -128,10 +139,45 @@ type ResponseError struct {
 	Data json.RawMessage `json:"data"`
 }
 
+func (e *ResponseError) Error() string {
+	return fmt.Sprintf("%s (code %d)", e.Message, e.Code)
+}
+
 type Response struct {
-	RPCVersion string          `json:"jsonrpc"`
-	ID         uint64          `json:"id"`
-	Result     json.RawMessage `json:"result"`
+	RPCVersion string `json:"jsonrpc"`
+	ID         ID     `json:"id"`
+	// The result of successful request, must be set, at least to null, unless request fails.
+	Result json.RawMessage `json:"result,omitempty"`
 	// The error object in case a request fails.
-	Error *ResponseError `json:"error"`
+	Error *ResponseError `json:"error,omitempty"`
+}
+
+// Message is any message received from the other side: request, notification or response.
+type Message struct {
+	RPCVersion string          `json:"jsonrpc"`
+	ID         *ID             `json:"id,omitempty"`
+	Method     string          `json:"method,omitempty"`
+	Params     json.RawMessage `json:"params,omitempty"`
+	Result     json.RawMessage `json:"result,omitempty"`
+	Error      *ResponseError  `json:"error,omitempty"`
+}
+
+// IsRequest reports whether message is a request, which must be responded to.
+func (m Message) IsRequest() bool {
+	return m.Method != "" && m.ID != nil
+}
+
+// IsNotification reports whether message is a notification, which must not be responded to.
+func (m Message) IsNotification() bool {
+	return m.Method != "" && m.ID == nil
+}
+
+// IsResponse reports whether message is a response to request.
+func (m Message) IsResponse() bool {
+	return m.Method == "" && m.ID != nil
+}
+
+// Response returns message as response, valid only if IsResponse is true.
+func (m Message) Response() Response {
+	return Response{RPCVersion: m.RPCVersion, ID: *m.ID, Result: m.Result, Error: m.Error}
 }
@@ -1,6 +1,11 @@
 // https://microsoft.github.io/language-server-protocol/specifications/lsp/3.17/specification/
 package lsp
 
+import (
+	"bytes"
+	"encoding/json"
+)
+
 type URI string
 
 type WorkspaceFolder struct {
@@ -58,7 +63,7 @@ type InitializeParams struct {
 type ClientCapabilities struct {
 	// Workspace    WorkspaceClientCapabilities    `json:"workspace"`
 	TextDocument TextDocumentClientCapabilities `json:"textDocument"`
-	// Window       WindowClientCapabilities       `json:"window"`
+	Window       WindowClientCapabilities       `json:"window"`
 	// Experimental any                            `json:"experimental"`
 }
 
@@ -141,17 +146,22 @@ type TextDocumentClientCapabilities struct {
 	// 		SemanticHighlighting bool `json:"semanticHighlighting,omitempty"`
 	// 	} `json:"semanticHighlightingCapabilities,omitempty"`
 
-	// 	CodeAction struct {
-	// 		DynamicRegistration bool `json:"dynamicRegistration,omitempty"`
+	CodeAction *struct {
+		// 	DynamicRegistration bool `json:"dynamicRegistration,omitempty"`
 
-	// 		IsPreferredSupport bool `json:"isPreferredSupport,omitempty"`
+		// 	IsPreferredSupport bool `json:"isPreferredSupport,omitempty"`
 
-	// 		CodeActionLiteralSupport struct {
-	// 			CodeActionKind struct {
-	// 				ValueSet []CodeActionKind `json:"valueSet,omitempty"`
-	// 			} `json:"codeActionKind"`
-	// 		} `json:"codeActionLiteralSupport"`
-	// 	} `json:"codeAction"`
+		// 	CodeActionLiteralSupport struct {
+		// 		CodeActionKind struct {
+		// 			ValueSet []CodeActionKind `json:"valueSet,omitempty"`
+		// 		} `json:"codeActionKind"`
+		// 	} `json:"codeActionLiteralSupport"`
+
+		// Properties of code action which client can resolve lazily via codeAction/resolve request.
+		ResolveSupport *struct {
+			Properties []string `json:"properties"`
+		} `json:"resolveSupport,omitempty"`
+	} `json:"codeAction,omitempty"`
 
 	// 	Completion struct {
 	// 		CompletionItem struct {
@@ -201,12 +211,12 @@ type TextDocumentClientCapabilities struct {
 	//	} `json:"colorProvider,omitempty"`
 }
 
-// type WindowClientCapabilities struct {
-// 	WorkDoneProgress bool `json:"workDoneProgress,omitempty"`
-// }
+type WindowClientCapabilities struct {
+	WorkDoneProgress bool `json:"workDoneProgress,omitempty"`
+}
 
 type InitializeResult struct {
-	// Capabilities ServerCapabilities `json:"capabilities"`
+	Capabilities ServerCapabilities `json:"capabilities"`
 }
 
 // type InitializeError struct {
@@ -221,98 +231,88 @@ type InitializeResult struct {
 // 	RORename ResourceOperation = "rename"
 // )
 
-// // TextDocumentSyncKind is a DEPRECATED way to describe how text
-// // document syncing works. Use TextDocumentSyncOptions instead (or the
-// // Options field of TextDocumentSyncOptionsOrKind if you need to
-// // support JSON-(un)marshaling both).
-// type TextDocumentSyncKind int
+// TextDocumentSyncKind is a DEPRECATED way to describe how text
+// document syncing works. Use TextDocumentSyncOptions instead (or the
+// Options field of TextDocumentSyncOptionsOrKind if you need to
+// support JSON-(un)marshaling both).
+type TextDocumentSyncKind int
 
-// const (
-// 	TDSKNone        TextDocumentSyncKind = 0
-// 	TDSKFull        TextDocumentSyncKind = 1
-// 	TDSKIncremental TextDocumentSyncKind = 2
-// )
+const (
+	TDSKNone        TextDocumentSyncKind = 0
+	TDSKFull        TextDocumentSyncKind = 1
+	TDSKIncremental TextDocumentSyncKind = 2
+)
 
-// type TextDocumentSyncOptions struct {
-// 	OpenClose         bool                 `json:"openClose,omitempty"`
-// 	Change            TextDocumentSyncKind `json:"change"`
-// 	WillSave          bool                 `json:"willSave,omitempty"`
-// 	WillSaveWaitUntil bool                 `json:"willSaveWaitUntil,omitempty"`
-// 	Save              *SaveOptions         `json:"save,omitempty"`
-// }
+type TextDocumentSyncOptions struct {
+	OpenClose         bool                 `json:"openClose,omitempty"`
+	Change            TextDocumentSyncKind `json:"change"`
+	WillSave          bool                 `json:"willSave,omitempty"`
+	WillSaveWaitUntil bool                 `json:"willSaveWaitUntil,omitempty"`
+	Save              *SaveOptions         `json:"save,omitempty"`
+}
 
-// // TextDocumentSyncOptions holds either a TextDocumentSyncKind or
-// // TextDocumentSyncOptions. The LSP API allows either to be specified
-// // in the (ServerCapabilities).TextDocumentSync field.
-// type TextDocumentSyncOptionsOrKind struct {
-// 	Kind    *TextDocumentSyncKind
-// 	Options *TextDocumentSyncOptions
-// }
+// TextDocumentSyncOptions holds either a TextDocumentSyncKind or
+// TextDocumentSyncOptions. The LSP API allows either to be specified
+// in the (ServerCapabilities).TextDocumentSync field.
+type TextDocumentSyncOptionsOrKind struct {
+	Kind    *TextDocumentSyncKind
+	Options *TextDocumentSyncOptions
+}
 
-// // MarshalJSON implements json.Marshaler.
-// func (v *TextDocumentSyncOptionsOrKind) MarshalJSON() ([]byte, error) {
-// 	if v == nil {
-// 		return []byte("null"), nil
-// 	}
-// 	if v.Kind != nil {
-// 		return json.Marshal(v.Kind)
-// 	}
-// 	return json.Marshal(v.Options)
-// }
+// MarshalJSON implements json.Marshaler.
+func (v *TextDocumentSyncOptionsOrKind) MarshalJSON() ([]byte, error) {
+	if v == nil {
+		return []byte("null"), nil
+	}
+	if v.Kind != nil {
+		return json.Marshal(v.Kind)
+	}
+	return json.Marshal(v.Options)
+}
 
-// // UnmarshalJSON implements json.Unmarshaler.
-// func (v *TextDocumentSyncOptionsOrKind) UnmarshalJSON(data []byte) error {
-// 	if bytes.Equal(data, []byte("null")) {
-// 		*v = TextDocumentSyncOptionsOrKind{}
-// 		return nil
-// 	}
-// 	var kind TextDocumentSyncKind
-// 	if err := json.Unmarshal(data, &kind); err == nil {
-// 		// Create equivalent TextDocumentSyncOptions using the same
-// 		// logic as in vscode-languageclient. Also set the Kind field
-// 		// so that JSON-marshaling and unmarshaling are inverse
-// 		// operations (for backward compatibility, preserving the
-// 		// original input but accepting both).
-// 		*v = TextDocumentSyncOptionsOrKind{
-// 			Options: &TextDocumentSyncOptions{OpenClose: true, Change: kind},
-// 			Kind:    &kind,
-// 		}
-// 		return nil
-// 	}
-// 	var tmp TextDocumentSyncOptions
-// 	if err := json.Unmarshal(data, &tmp); err != nil {
-// 		return err
-// 	}
-// 	*v = TextDocumentSyncOptionsOrKind{Options: &tmp}
-// 	return nil
-// }
+// UnmarshalJSON implements json.Unmarshaler.
+func (v *TextDocumentSyncOptionsOrKind) UnmarshalJSON(data []byte) error {
+	if bytes.Equal(data, []byte("null")) {
+		*v = TextDocumentSyncOptionsOrKind{}
+		return nil
+	}
+	var kind TextDocumentSyncKind
+	if err := json.Unmarshal(data, &kind); err == nil {
+		// Create equivalent TextDocumentSyncOptions using the same
+		// logic as in vscode-languageclient. Also set the Kind field
+		// so that JSON-marshaling and unmarshaling are inverse
+		// operations (for backward compatibility, preserving the
+		// original input but accepting both).
+		*v = TextDocumentSyncOptionsOrKind{
+			Options: &TextDocumentSyncOptions{OpenClose: true, Change: kind},
+			Kind:    &kind,
+		}
+		return nil
+	}
+	var tmp TextDocumentSyncOptions
+	if err := json.Unmarshal(data, &tmp); err != nil {
+		return err
+	}
+	*v = TextDocumentSyncOptionsOrKind{Options: &tmp}
+	return nil
+}
 
-// type SaveOptions struct {
-// 	IncludeText bool `json:"includeText"`
-// }
-
-// type ServerCapabilities struct {
-// 	TextDocumentSync                 *TextDocumentSyncOptionsOrKind   `json:"textDocumentSync,omitempty"`
-// 	HoverProvider                    bool                             `json:"hoverProvider,omitempty"`
-// 	CompletionProvider               *CompletionOptions               `json:"completionProvider,omitempty"`
-// 	SignatureHelpProvider            *SignatureHelpOptions            `json:"signatureHelpProvider,omitempty"`
-// 	DefinitionProvider               bool                             `json:"definitionProvider,omitempty"`
-// 	TypeDefinitionProvider           bool                             `json:"typeDefinitionProvider,omitempty"`
-// 	ReferencesProvider               bool                             `json:"referencesProvider,omitempty"`
-// 	DocumentHighlightProvider        bool                             `json:"documentHighlightProvider,omitempty"`
-// 	DocumentSymbolProvider           bool                             `json:"documentSymbolProvider,omitempty"`
-// 	WorkspaceSymbolProvider          bool                             `json:"workspaceSymbolProvider,omitempty"`
-// 	ImplementationProvider           bool                             `json:"implementationProvider,omitempty"`
-// 	CodeActionProvider               bool                             `json:"codeActionProvider,omitempty"`
-// 	CodeLensProvider                 *CodeLensOptions                 `json:"codeLensProvider,omitempty"`
-// 	DocumentFormattingProvider       bool                             `json:"documentFormattingProvider,omitempty"`
-// 	DocumentRangeFormattingProvider  bool                             `json:"documentRangeFormattingProvider,omitempty"`
-// 	DocumentOnTypeFormattingProvider *DocumentOnTypeFormattingOptions `json:"documentOnTypeFormattingProvider,omitempty"`
-// 	RenameProvider                   bool                             `json:"renameProvider,omitempty"`
-// 	ExecuteCommandProvider           *ExecuteCommandOptions           `json:"executeCommandProvider,omitempty"`
-// 	SemanticHighlighting             *SemanticHighlightingOptions     `json:"semanticHighlighting,omitempty"`
-// 	Experimental                     any                              `json:"experimental,omitempty"`
-// }
+type SaveOptions struct {
+	IncludeText bool `json:"includeText"`
+}
+
+type ServerCapabilities struct {
+	TextDocumentSync *TextDocumentSyncOptionsOrKind `json:"textDocumentSync,omitempty"`
+	// CodeActionProvider is either bool or CodeActionOptions.
+	CodeActionProvider any `json:"codeActionProvider,omitempty"`
+}
+
+type CodeActionOptions struct {
+	// CodeActionKinds that server may return.
+	CodeActionKinds []CodeActionKind `json:"codeActionKinds,omitempty"`
+	// ResolveProvider is set if server resolves code actions via codeAction/resolve request.
+	ResolveProvider bool `json:"resolveProvider,omitempty"`
+}
 
 // type CompletionOptions struct {
 // 	ResolveProvider   bool     `json:"resolveProvider,omitempty"`
@@ -639,31 +639,48 @@ var symbolKindName = [...]string{
 // 	ContainerName string     `json:"containerName,omitempty"`
 // }
 
-// type WorkspaceSymbolParams struct {
-// 	Query string `json:"query"`
-// 	Limit int    `json:"limit"`
-// }
+type WorkspaceSymbolParams struct {
+	Query string `json:"query"`
+}
 
-// type ConfigurationParams struct {
-// 	Items []ConfigurationItem `json:"items"`
-// }
+type ConfigurationParams struct {
+	Items []ConfigurationItem `json:"items"`
+}
 
-// type ConfigurationItem struct {
-// 	ScopeURI string `json:"scopeUri,omitempty"`
-// 	Section  string `json:"section,omitempty"`
-// }
+type ConfigurationItem struct {
+	ScopeURI string `json:"scopeUri,omitempty"`
+	Section  string `json:"section,omitempty"`
+}
 
-// type ConfigurationResult []any
+type ConfigurationResult []any
 
-// type CodeActionContext struct {
-// 	Diagnostics []Diagnostic `json:"diagnostics"`
-// }
+type CodeActionContext struct {
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
 
-// type CodeActionParams struct {
-// 	TextDocument TextDocumentIdentifier `json:"textDocument"`
-// 	Range        Range                  `json:"range"`
-// 	Context      CodeActionContext      `json:"context"`
-// }
+type CodeActionParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Range        Range                  `json:"range"`
+	Context      CodeActionContext      `json:"context"`
+}
+
+type CodeActionKind string
+
+const (
+	CodeActionQuickFix CodeActionKind = "quickfix"
+)
+
+type CodeAction struct {
+	// A short, human-readable, title for this code action.
+	Title string         `json:"title"`
+	Kind  CodeActionKind `json:"kind,omitempty"`
+	// The diagnostics that this code action resolves.
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"`
+	// The workspace edit this code action performs.
+	Edit *WorkspaceEdit `json:"edit,omitempty"`
+	// Data is preserved between textDocument/codeAction and codeAction/resolve requests.
+	Data json.RawMessage `json:"data,omitempty"`
+}
 
 // type CodeLensParams struct {
 // 	TextDocument TextDocumentIdentifier `json:"textDocument"`
@@ -686,48 +703,48 @@ var symbolKindName = [...]string{
 // 	Key          string `json:"key"`
 // }
 
-// type RenameParams struct {
-// 	TextDocument TextDocumentIdentifier `json:"textDocument"`
-// 	Position     Position               `json:"position"`
-// 	NewName      string                 `json:"newName"`
-// }
+type RenameParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+	Position     Position               `json:"position"`
+	NewName      string                 `json:"newName"`
+}
 
 type DidOpenTextDocumentParams struct {
 	TextDocument TextDocumentItem `json:"textDocument"`
 }
 
-// type DidChangeTextDocumentParams struct {
-// 	TextDocument   VersionedTextDocumentIdentifier  `json:"textDocument"`
-// 	ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
-// }
+type DidChangeTextDocumentParams struct {
+	TextDocument   VersionedTextDocumentIdentifier  `json:"textDocument"`
+	ContentChanges []TextDocumentContentChangeEvent `json:"contentChanges"`
+}
 
-// type TextDocumentContentChangeEvent struct {
-// 	Range       *Range `json:"range,omitempty"`
-// 	RangeLength uint   `json:"rangeLength,omitempty"`
-// 	Text        string `json:"text"`
-// }
+type TextDocumentContentChangeEvent struct {
+	Range       *Range `json:"range,omitempty"`
+	RangeLength uint   `json:"rangeLength,omitempty"`
+	Text        string `json:"text"`
+}
 
-// type DidCloseTextDocumentParams struct {
-// 	TextDocument TextDocumentIdentifier `json:"textDocument"`
-// }
+type DidCloseTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
 
-// type DidSaveTextDocumentParams struct {
-// 	TextDocument TextDocumentIdentifier `json:"textDocument"`
-// }
+type DidSaveTextDocumentParams struct {
+	TextDocument TextDocumentIdentifier `json:"textDocument"`
+}
 
-// type MessageType int
+type MessageType int
 
-// const (
-// 	MTError   MessageType = 1
-// 	MTWarning MessageType = 2
-// 	Info      MessageType = 3
-// 	Log       MessageType = 4
-// )
+const (
+	MTError   MessageType = 1
+	MTWarning MessageType = 2
+	MTInfo    MessageType = 3
+	MTLog     MessageType = 4
+)
 
-// type ShowMessageParams struct {
-// 	Type    MessageType `json:"type"`
-// 	Message string      `json:"message"`
-// }
+type ShowMessageParams struct {
+	Type    MessageType `json:"type"`
+	Message string      `json:"message"`
+}
 
 // type MessageActionItem struct {
 // 	Title string `json:"title"`
@@ -739,10 +756,10 @@ type DidOpenTextDocumentParams struct {
 // 	Actions []MessageActionItem `json:"actions"`
 // }
 
-// type LogMessageParams struct {
-// 	Type    MessageType `json:"type"`
-// 	Message string      `json:"message"`
-// }
+type LogMessageParams struct {
+	Type    MessageType `json:"type"`
+	Message string      `json:"message"`
+}
 
 // type DidChangeConfigurationParams struct {
 // 	Settings any `json:"settings"`
@@ -897,3 +914,19 @@ type DidOpenTextDocumentParams struct {
 // }
 
 type InitializedParams struct{}
+
+type ProgressParams struct {
+	// The progress token provided by the client or server, string or integer.
+	Token json.RawMessage `json:"token"`
+	// The progress data, WorkDoneProgress for work done progress.
+	Value json.RawMessage `json:"value"`
+}
+
+// WorkDoneProgress is value of work done progress notification, Kind is one of
+// begin, report and end, Title is set only on begin.
+type WorkDoneProgress struct {
+	Kind       string `json:"kind"`
+	Title      string `json:"title,omitempty"`
+	Message    string `json:"message,omitempty"`
+	Percentage uint   `json:"percentage,omitempty"`
+}
@@ -29,37 +29,57 @@ type Location struct {
 	Range Range `json:"range"`
 }
 
-// type Diagnostic struct {
-// 	// The range at which the message applies.
-// 	Range Range `json:"range"`
-
-// 	/**
-// 	 * The diagnostic's severity. Can be omitted. If omitted it is up to the
-// 	 * client to interpret diagnostics as error, warning, info or hint.
-// 	 */
-// 	Severity DiagnosticSeverity `json:"severity,omitempty"`
-
-// 	// The diagnostic's code. Can be omitted.
-// 	Code string `json:"code,omitempty"`
-
-// 	/**
-// 	 * A human-readable string describing the source of this
-// 	 * diagnostic, e.g. 'typescript' or 'super lint'.
-// 	 */
-// 	Source string `json:"source,omitempty"`
-
-// 	// The diagnostic's message.
-// 	Message string `json:"message"`
-// }
+type Diagnostic struct {
+	// The range at which the message applies.
+	Range Range `json:"range"`
+
+	/**
+	 * The diagnostic's severity. Can be omitted. If omitted it is up to the
+	 * client to interpret diagnostics as error, warning, info or hint.
+	 */
+	Severity DiagnosticSeverity `json:"severity,omitempty"`
+
+	// The diagnostic's code. Can be omitted.
+	Code string `json:"code,omitempty"`
+
+	/**
+	 * A human-readable string describing the source of this
+	 * diagnostic, e.g. 'typescript' or 'super lint'.
+	 */
+	Source string `json:"source,omitempty"`
+
+	// The diagnostic's message.
+	Message string `json:"message"`
+
+	// Additional metadata about the diagnostic.
+	Tags []DiagnosticTag `json:"tags,omitempty"`
+}
 
-// type DiagnosticSeverity int
+type DiagnosticSeverity int
 
-// const (
-// 	Error       DiagnosticSeverity = 1
-// 	Warning     DiagnosticSeverity = 2
-// 	Information DiagnosticSeverity = 3
-// 	Hint        DiagnosticSeverity = 4
-// )
+const (
+	Error       DiagnosticSeverity = 1
+	Warning     DiagnosticSeverity = 2
+	Information DiagnosticSeverity = 3
+	Hint        DiagnosticSeverity = 4
+)
+
+type DiagnosticTag int
+
+const (
+	// Unused or unnecessary code. Clients are allowed to render diagnostics
+	// with this tag faded out instead of having an error squiggle.
+	Unnecessary DiagnosticTag = 1
+	// Deprecated or obsolete code.
+	Deprecated DiagnosticTag = 2
+)
+
+type PublishDiagnosticsParams struct {
+	// The URI for which diagnostic information is reported.
+	URI URI `json:"uri"`
+	// An array of diagnostic information items.
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
 
 // type Command struct {
 // 	// Title of the command, like `save`.
@@ -70,20 +90,41 @@ type Location struct {
 // 	Arguments []any `json:"arguments"`
 // }
 
-// type TextEdit struct {
-// 	/**
-// 	 * The range of the text document to be manipulated. To insert
-// 	 * text into a document create a range where start === end.
-// 	 */
-// 	Range Range `json:"range"`
-// 	// The string to be inserted. For delete operations use an empty string.
-// 	NewText string `json:"newText"`
-// }
+type TextEdit struct {
+	/**
+	 * The range of the text document to be manipulated. To insert
+	 * text into a document create a range where start === end.
+	 */
+	Range Range `json:"range"`
+	// The string to be inserted. For delete operations use an empty string.
+	NewText string `json:"newText"`
+}
 
-// type WorkspaceEdit struct {
-// 	// Holds changes to existing resources.
-// 	Changes map[string][]TextEdit `json:"changes"`
-// }
+type TextDocumentEdit struct {
+	// The text document to change.
+	TextDocument VersionedTextDocumentIdentifier `json:"textDocument"`
+	// The edits to be applied.
+	Edits []TextEdit `json:"edits"`
+}
+
+type WorkspaceEdit struct {
+	// Holds changes to existing resources.
+	Changes map[URI][]TextEdit `json:"changes,omitempty"`
+	// Versioned changes to existing resources, file operations are not supported.
+	DocumentChanges []TextDocumentEdit `json:"documentChanges,omitempty"`
+}
+
+// Edits returns all text edits grouped by document, regardless of whether server sent them as changes or documentChanges.
+func (e WorkspaceEdit) Edits() map[URI][]TextEdit {
+	res := make(map[URI][]TextEdit, len(e.Changes)+len(e.DocumentChanges))
+	for uri, edits := range e.Changes {
+		res[uri] = append(res[uri], edits...)
+	}
+	for _, change := range e.DocumentChanges {
+		res[change.TextDocument.URI] = append(res[change.TextDocument.URI], change.Edits...)
+	}
+	return res
+}
 
 type TextDocumentIdentifier struct {
 	// The text document's URI.
@@ -106,11 +147,11 @@ type TextDocumentItem struct {
 	Text string `json:"text"`
 }
 
-// type VersionedTextDocumentIdentifier struct {
-// 	TextDocumentIdentifier
-// 	// The version number of this document.
-// 	Version int `json:"version"`
-// }
+type VersionedTextDocumentIdentifier struct {
+	TextDocumentIdentifier
+	// The version number of this document.
+	Version int `json:"version"`
+}
 
 type TextDocumentPositionParams struct {
 	// The text document.
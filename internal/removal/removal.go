@@ -0,0 +1,124 @@
+// Package removal finds byte ranges of source to delete to remove declarations.
+package removal
+
+import (
+	"go/ast"
+	"go/token"
+
+	"golang.org/x/tools/go/ast/astutil"
+)
+
+// Span is a byte range [Start, End) in file.
+type Span struct {
+	Start, End int
+}
+
+// Decl returns span of node together with its doc and trailing comments.
+func Decl(fset *token.FileSet, node ast.Node, doc, comment *ast.CommentGroup) Span {
+	start, end := node.Pos(), node.End()
+	if doc != nil {
+		start = doc.Pos()
+	}
+	if comment != nil {
+		end = max(end, comment.End())
+	}
+	return Span{fset.Position(start).Offset, fset.Position(end).Offset}
+}
+
+// ExpandToLines expands span to whole lines, if span is the only thing on its lines.
+func ExpandToLines(src []byte, s Span) Span {
+	start := s.Start
+	for start > 0 && (src[start-1] == ' ' || src[start-1] == '\t') {
+		start--
+	}
+	end := s.End
+	for end < len(src) && (src[end] == ' ' || src[end] == '\t') {
+		end++
+	}
+	if (start == 0 || src[start-1] == '\n') && (end == len(src) || src[end] == '\n') {
+		return Span{start, min(end+1, len(src))}
+	}
+	return s
+}
+
+// At returns span to remove declaration of symbol, name of which is at pos.
+// Returns false if symbol can't be removed automatically, e.g. if declared
+// together with other symbols as in var A, B = 1, 2, or if it is constant
+// in group using iota.
+func At(fset *token.FileSet, f *ast.File, pos token.Pos) (Span, bool) {
+	path, _ := astutil.PathEnclosingInterval(f, pos, pos)
+	if len(path) < 2 {
+		return Span{}, false
+	}
+	if _, ok := path[0].(*ast.Ident); !ok {
+		return Span{}, false
+	}
+
+	switch parent := path[1].(type) {
+	case *ast.FuncDecl:
+		return Decl(fset, parent, parent.Doc, nil), true
+	case *ast.Field:
+		if len(parent.Names) != 1 {
+			return Span{}, false
+		}
+		return Decl(fset, parent, parent.Doc, parent.Comment), true
+	case *ast.ValueSpec, *ast.TypeSpec:
+		var doc, comment *ast.CommentGroup
+		switch spec := parent.(type) {
+		case *ast.ValueSpec:
+			if len(spec.Names) != 1 {
+				return Span{}, false
+			}
+			if decl, ok := path[2].(*ast.GenDecl); ok && len(decl.Specs) > 1 && isPositional(decl) {
+				return Span{}, false
+			}
+			doc, comment = spec.Doc, spec.Comment
+		case *ast.TypeSpec:
+			doc, comment = spec.Doc, spec.Comment
+		}
+
+		decl, ok := path[2].(*ast.GenDecl)
+		if ok && len(decl.Specs) == 1 {
+			// remove whole declaration, not leaving empty var () behind
+			sp := Decl(fset, decl, decl.Doc, nil)
+			sp.End = max(sp.End, Decl(fset, parent, doc, comment).End)
+			return sp, true
+		}
+		return Decl(fset, parent, doc, comment), true
+	default:
+		return Span{}, false
+	}
+}
+
+// isPositional checks whether values of constants in group depend on their order, i.e. group
+// uses iota or repeats expressions implicitly, so that removing any spec renumbers the
+// following constants or leaves them without value. Variable groups are never positional.
+func isPositional(decl *ast.GenDecl) bool {
+	if decl.Tok != token.CONST {
+		return false
+	}
+
+	for _, spec := range decl.Specs {
+		vs, ok := spec.(*ast.ValueSpec)
+		if !ok {
+			continue
+		}
+		if len(vs.Values) == 0 {
+			return true
+		}
+
+		iota := false
+		for _, v := range vs.Values {
+			ast.Inspect(v, func(n ast.Node) bool {
+				if id, ok := n.(*ast.Ident); ok && id.Name == "iota" {
+					iota = true
+				}
+				return !iota
+			})
+		}
+		if iota {
+			return true
+		}
+	}
+	return false
+}
@@ -1,149 +1,352 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"flag"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"os"
 	"os/signal"
 	"path/filepath"
+	"runtime"
+	"slices"
 	"strings"
-	"syscall"
-	"time"
 
 	"github.com/gobwas/glob"
 	"github.com/pkg/errors"
-	yaml "gopkg.in/yaml.v3"
-)
 
-const (
-	_configFilename = ".punused.yaml"
-	_defaultTimeout = 10 * time.Minute
+	"github.com/rprtr258/punused/engine"
 )
 
-type Config struct {
-	ExcludedPaths   []glob.Glob
-	ExcludedSymbols []string
-	Timeout         time.Duration
+// _logLevelEnv is environment variable with default log level.
+const _logLevelEnv = "PUNUSED_LOG_LEVEL"
+
+// errDiagnosticsFound is returned from run if any failing diagnostic is found.
+var errDiagnosticsFound = errors.New("diagnostics found")
+
+type options struct {
+	// Patterns are globs of files to check, file is checked if it matches any of them.
+	Patterns     []string
+	WorkspaceDir string
+	// ConfigFile is config file to use, if empty, it is searched from workspace directory upward.
+	ConfigFile string
+	// SkipTests makes _test.go files not checked, references from them are still counted.
+	SkipTests   bool
+	Format      outputFormat
+	FailOnStale bool
+	// BaselineFile is file with known diagnostics, which are not reported.
+	BaselineFile string
+	// WriteBaseline makes run write all diagnostics to BaselineFile instead of reporting them.
+	WriteBaseline bool
+	// Fix makes run remove unused symbols instead of reporting them.
+	Fix bool
+	// DryRun makes fix write unified diff to output instead of changing files.
+	// Diagnostics are reported to stderr then.
+	DryRun bool
+	// Unexport enables reporting, and fixing if Fix is set, of exported symbols used only in their own package.
+	Unexport bool
+	// LogLevel is minimal level of messages logged to stderr.
+	LogLevel slog.Level
+	// Concurrency is max number of reference queries in flight, number of CPUs if zero.
+	Concurrency int
+	// Backend is backend used to find symbols and references, gopls if empty.
+	Backend engine.Backend
+	// Explain is symbol in form <pkg>.<Symbol> to explain usage of instead of reporting diagnostics.
+	Explain string
+	// LSP makes punused run language server on stdin and stdout instead of reporting diagnostics.
+	LSP bool
+	// Since is git revision, if set, only changes since it are checked.
+	Since string
 }
 
-func readYAMLConfig(filename string) (Config, error) {
-	bytes, err := os.ReadFile(filename)
-	if err != nil {
-		return Config{}, err
+func (o options) engineOptions() engine.Options {
+	return engine.Options{
+		WorkspaceDir:       o.WorkspaceDir,
+		ConfigFile:         o.ConfigFile,
+		Patterns:           o.Patterns,
+		SkipTests:          o.SkipTests,
+		ReportUnexportable: o.Unexport,
+		FailOnStale:        o.FailOnStale,
+		Backend:            o.Backend,
+		Concurrency:        o.Concurrency,
+		Since:              o.Since,
 	}
+}
 
-	var c Config
-	if err := yaml.Unmarshal(bytes, &c); err != nil {
-		return Config{}, err
+func run(ctx context.Context, opts options, w io.Writer) (err error) {
+	wd := opts.WorkspaceDir
+	eopts := opts.engineOptions()
+
+	if opts.Explain != "" {
+		return engine.Explain(ctx, eopts, opts.Explain, w)
 	}
-	return c, nil
-}
 
-func run(
-	ctx context.Context,
-	matcher glob.Glob,
-	wd string,
-	skipTests bool, // TODO: skip tests flag
-	w io.Writer,
-) (err error) {
-	config, err := readYAMLConfig(_configFilename)
-	if err != nil {
-		var e syscall.Errno
-		if !errors.As(err, &e) || !e.Is(os.ErrNotExist) {
-			fmt.Fprintln(os.Stderr, fmt.Errorf("read config file: %w", err).Error())
-			os.Exit(1)
+	diagOut := w
+	if opts.Fix && opts.DryRun {
+		diagOut = os.Stderr
+	}
+
+	var rep reporter
+	if opts.WriteBaseline {
+		rep = &baselineReporter{opts.BaselineFile, []baselineEntry{}}
+	} else if rep, err = newReporter(opts.Format, diagOut, wd); err != nil {
+		return err
+	}
+
+	known := baseline{}
+	if opts.BaselineFile != "" && !opts.WriteBaseline {
+		if known, err = readBaseline(opts.BaselineFile); err != nil {
+			return err
 		}
+	}
 
-		log.Println("no config file found, using default config")
-		config = Config{
-			ExcludedPaths:   nil,
-			ExcludedSymbols: nil,
-			Timeout:         _defaultTimeout,
+	reported, failed := 0, 0
+	emit := func(f engine.Finding) error {
+		reported++
+		if f.Severity == engine.SeverityError {
+			failed++
 		}
+		return rep.Report(f)
 	}
 
-	ctx, cancel := context.WithTimeout(ctx, config.Timeout)
-	defer cancel()
+	// findings in baseline are not reported, fixable ones are reported only if fixing fails
+	var toFix []engine.Finding
+	for f, err := range engine.Analyze(ctx, eopts) {
+		if err != nil {
+			return err
+		}
+		if known.consume(newBaselineEntry(f)) {
+			continue
+		}
+		if opts.Fix && f.Fixable() {
+			toFix = append(toFix, f)
+			continue
+		}
+		if err := emit(f); err != nil {
+			return err
+		}
+	}
+
+	if len(toFix) > 0 {
+		var diff io.Writer
+		if opts.DryRun {
+			diff = w
+		}
+		skipped, err := engine.Fix(ctx, eopts, toFix, diff)
+		if err != nil {
+			return err
+		}
+		for _, f := range skipped {
+			if err := emit(f); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := rep.Flush(); err != nil {
+		return err
+	}
+
+	if opts.WriteBaseline {
+		slog.Info("baseline written", "diagnostics", reported, "file", opts.BaselineFile)
+		return nil
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%w: %d", errDiagnosticsFound, failed)
+	}
+	return nil
+}
+
+// parseArgs parses command line arguments, without program name, into options.
+func parseArgs(args []string) (options, error) {
+	// punused baseline [flags] [patterns] writes baseline instead of reporting diagnostics
+	writeBaseline := len(args) > 0 && args[0] == "baseline"
+	if writeBaseline {
+		args = args[1:]
+	}
+	// punused explain [flags] <pkg>.<Symbol> [patterns] explains usage of symbol
+	explain := len(args) > 0 && args[0] == "explain"
+	if explain {
+		args = args[1:]
+	}
+	// punused lsp [flags] [patterns] runs language server
+	lsp := len(args) > 0 && args[0] == "lsp"
+	if lsp {
+		args = args[1:]
+	}
 
-	cfg := RunConfig{
-		SkipTests:       skipTests,
-		FilenameMatcher: matcher,
-		WorkspaceDir:    wd,
-		ExcludedPaths:   config.ExcludedPaths,
-		ExcludedSymbols: config.ExcludedSymbols,
+	fs := flag.NewFlagSet("punused", flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: punused [baseline] [flags] [patterns...]\n"+
+			"       punused explain [flags] <pkg>.<Symbol> [patterns...]\n"+
+			"       punused lsp [flags] [patterns...]\n\n"+
+			"Patterns are globs of Go files to check, relative to workspace directory (default \"**/*.go\").\n\nFlags:\n")
+		fs.PrintDefaults()
+	}
+	workspaceDir := fs.String("C", ".", "workspace directory, must be root of Go module, for lsp command default is workspace opened in editor")
+	configFile := fs.String("config", "", "config file (default is first of "+strings.Join(engine.ConfigFilenames, ", ")+
+		" found in workspace directory or its parents up to repository root)")
+	tests := fs.Bool("tests", true, "check symbols declared in _test.go files, references from tests are counted regardless")
+	format := fs.String("format", string(formatText), fmt.Sprintf("output format, one of %v", outputFormats))
+	failOnStale := fs.Bool("fail-on-stale", false, "exit with non-zero code if there are stale suppressions")
+	baselineFile := fs.String("baseline", "", "report only diagnostics not found in baseline file, "+
+		"for baseline command it is file to write baseline to (default "+_defaultBaselineFilename+")")
+	fix := fs.Bool("fix", false, "remove unused symbols")
+	dryRun := fs.Bool("dry-run", false, "with -fix, print unified diff instead of changing files")
+	unexport := fs.Bool("unexport", false, "report exported symbols used only in their own package, with -fix unexport them")
+	logLevel := fs.String("log-level", cmp.Or(os.Getenv(_logLevelEnv), "info"),
+		"minimal level of logged messages, one of debug, info, warn, error, can be set with "+_logLevelEnv+" environment variable")
+	concurrency := fs.Int("j", runtime.NumCPU(), "max number of concurrent reference queries")
+	backendName := fs.String("backend", string(engine.BackendGopls), fmt.Sprintf("backend finding symbols and references, one of %v, "+
+		"packages type checks workspace in process and is faster, but can't unexport symbols", engine.Backends))
+	since := fs.String("since", "", "git revision, check only Go files changed since it and symbols of other files, "+
+		"whose last references were removed by changes")
+	verbose := fs.Bool("v", false, "same as -log-level=debug, log visited files, symbols, references, gopls requests and exclusions")
+	if err := fs.Parse(args); err != nil {
+		return options{}, err
+	}
+
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(*logLevel)); err != nil {
+		return options{}, fmt.Errorf("invalid log level %q: %w", *logLevel, err)
+	}
+	if *verbose {
+		level = slog.LevelDebug
 	}
 
-	// This needs to be run from the rooot of a Go Module to get correct results.
-	if _, err := os.Stat(filepath.Join(cfg.WorkspaceDir, "go.mod")); err != nil {
-		return fmt.Errorf("workspace %s is not a Go module (go.mod is missing): %w", cfg.WorkspaceDir, err)
+	if *concurrency < 1 {
+		return options{}, errors.New("-j must be positive")
+	}
+	if *dryRun && !*fix {
+		return options{}, errors.New("-dry-run requires -fix")
+	}
+	if !slices.Contains(engine.Backends, engine.Backend(*backendName)) {
+		return options{}, fmt.Errorf("unknown backend %q, must be one of %v", *backendName, engine.Backends)
+	}
+	if engine.Backend(*backendName) != engine.BackendGopls && *fix && *unexport {
+		return options{}, fmt.Errorf("-unexport -fix requires %s backend", engine.BackendGopls)
+	}
+	if lsp && *fix {
+		return options{}, errors.New("-fix can't be used with lsp, use code actions instead")
+	}
+	if *since != "" && (explain || lsp) {
+		return options{}, errors.New("-since can't be used with explain and lsp")
+	}
+	if writeBaseline && *baselineFile == "" {
+		*baselineFile = _defaultBaselineFilename
+	}
+
+	patterns := fs.Args()
+	var target string
+	if explain {
+		if len(patterns) == 0 {
+			return options{}, errors.New("explain requires symbol in form <pkg>.<Symbol>")
+		}
+		target, patterns = patterns[0], patterns[1:]
+		if _, _, err := engine.SplitTarget(target); err != nil {
+			return options{}, err
+		}
+	}
+	for _, pattern := range patterns {
+		if _, err := glob.Compile(pattern); err != nil {
+			return options{}, fmt.Errorf("invalid glob pattern %q: %w", pattern, err)
+		}
 	}
 
-	client, err := newClient(ctx, cfg.WorkspaceDir)
+	wd, err := filepath.Abs(*workspaceDir)
 	if err != nil {
-		return err
+		return options{}, errors.Wrap(err, "get workspace directory")
+	}
+	if lsp {
+		// language server uses workspace opened in editor, unless it is set explicitly
+		explicit := false
+		fs.Visit(func(f *flag.Flag) { explicit = explicit || f.Name == "C" })
+		if !explicit {
+			wd = ""
+		}
 	}
-	defer func() {
-		err = client.Close()
-	}()
 
-	r := &runner{cfg, client}
+	return options{
+		Patterns:      patterns,
+		WorkspaceDir:  wd,
+		ConfigFile:    *configFile,
+		SkipTests:     !*tests,
+		Format:        outputFormat(*format),
+		FailOnStale:   *failOnStale,
+		BaselineFile:  *baselineFile,
+		WriteBaseline: writeBaseline,
+		Fix:           *fix,
+		DryRun:        *dryRun,
+		Unexport:      *unexport,
+		LogLevel:      level,
+		Concurrency:   *concurrency,
+		Explain:       target,
+		Backend:       engine.Backend(*backendName),
+		LSP:           lsp,
+		Since:         *since,
+	}, nil
+}
 
-	// we have to preload everything, since otherwise gopls wont find all references,
-	// which gives much more false positives
-	for s := range r.diagnostics(r.symbols(r.Walk)) {
-		_ = s
+// runConfigCommand runs punused config subcommands:
+//
+//	punused config validate [file]
+func runConfigCommand(args []string, w io.Writer) error {
+	if len(args) == 0 || args[0] != "validate" || len(args) > 2 {
+		return errors.New("usage: punused config validate [file]")
 	}
 
-	for diag, err := range r.diagnostics(r.symbols(r.Walk)) {
-		if err != nil {
+	var filename string
+	if len(args) == 2 {
+		filename = args[1]
+	} else {
+		var err error
+		if filename, err = engine.FindConfig("."); err != nil {
 			return err
 		}
-		s := diag.Symbol
-		loc := s.SelectionRange.Start
-		path, err := filepath.Rel(cfg.WorkspaceDir, strings.TrimPrefix(string(diag.Symbol.URI), "file://"))
-		if err != nil {
-			return errors.Wrapf(err, "get relative path for %s", diag.Symbol.URI)
-		}
-		_, _ = fmt.Fprintf(w, "%s:%d:%d %s %s is ",
-			path,
-			loc.Line+1, loc.Character+1,
-			strings.ToLower(string(s.Kind.String())),
-			s.Name,
-		)
-		if diag.IsTestOnly {
-			_, _ = fmt.Fprintln(w, "used in test only (EU1001)")
-		} else {
-			_, _ = fmt.Fprintln(w, "unused (EU1002)")
+		if filename == "" {
+			return errors.New("no config file found")
 		}
 	}
 
-	return
+	if err := engine.ValidateConfig(filename, "."); err != nil {
+		return errors.Wrap(err, "invalid config")
+	}
+
+	fmt.Fprintf(w, "%s is valid\n", filename)
+	return nil
 }
 
 func main() {
-	// Default to "every go file in the workspace".
-	pattern := "**/*.go"
-	if len(os.Args) > 1 {
-		pattern = os.Args[1]
+	if len(os.Args) > 1 && os.Args[1] == "config" {
+		if err := runConfigCommand(os.Args[2:], os.Stdout); err != nil {
+			fmt.Fprintln(os.Stderr, err.Error())
+			os.Exit(1)
+		}
+		return
 	}
 
-	matcher, err := glob.Compile(pattern)
-	if err != nil {
-		log.Fatalf("invalid glob pattern: %v", err)
+	opts, err := parseArgs(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
 	}
-
-	wd, err := os.Getwd()
 	if err != nil {
-		log.Fatalf("get working directory: %v", err)
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(2)
 	}
 
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 
-	log.SetFlags(log.Lshortfile)
-	if err := run(ctx, matcher, wd, false, os.Stdout); err != nil {
+	slog.SetDefault(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: opts.LogLevel})))
+	if opts.LSP {
+		err = engine.Serve(ctx, opts.engineOptions(), os.Stdin, os.Stdout)
+	} else {
+		err = run(ctx, opts, os.Stdout)
+	}
+	if err != nil {
 		fmt.Fprintln(os.Stderr, err.Error())
 		os.Exit(1)
 	}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"testing"
+
+	"github.com/rprtr258/punused/engine"
+)
+
+func TestParseArgs(t *testing.T) {
+	opts, err := parseArgs([]string{"-tests=false", "-config", "cfg.yaml", "-format", "json", "-v", "a/**", "b/*.go"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !opts.SkipTests || opts.ConfigFile != "cfg.yaml" || opts.Format != formatJSON || opts.LogLevel != slog.LevelDebug || opts.WriteBaseline {
+		t.Errorf("unexpected options: %+v", opts)
+	}
+	if !slices.Equal(opts.Patterns, []string{"a/**", "b/*.go"}) {
+		t.Errorf("unexpected patterns: %v", opts.Patterns)
+	}
+
+	opts, err = parseArgs([]string{"baseline"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !opts.WriteBaseline || opts.BaselineFile != _defaultBaselineFilename || opts.SkipTests || len(opts.Patterns) != 0 {
+		t.Errorf("unexpected baseline options: %+v", opts)
+	}
+
+	if _, err := parseArgs([]string{"-dry-run"}); err == nil {
+		t.Error("expected error for -dry-run without -fix")
+	}
+
+	opts, err = parseArgs([]string{"-backend", "packages", "-fix"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if opts.Backend != engine.BackendPackages || !opts.Fix {
+		t.Errorf("unexpected backend options: %+v", opts)
+	}
+	for _, args := range [][]string{{"-backend", "guru"}, {"-backend", "packages", "-unexport", "-fix"}} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("%q: expected error for unsupported backend", args)
+		}
+	}
+
+	t.Setenv(_logLevelEnv, "warn")
+	opts, err = parseArgs(nil)
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if opts.LogLevel != slog.LevelWarn {
+		t.Errorf("expected log level from environment, got %v", opts.LogLevel)
+	}
+	if _, err := parseArgs([]string{"-log-level", "trace"}); err == nil {
+		t.Error("expected error for invalid log level")
+	}
+
+	opts, err = parseArgs([]string{"explain", "-tests=false", "internal/store.(*DB).Close", "internal/**"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if opts.Explain != "internal/store.(*DB).Close" || !opts.SkipTests ||
+		!slices.Equal(opts.Patterns, []string{"internal/**"}) {
+		t.Errorf("unexpected explain options: %+v", opts)
+	}
+
+	for _, args := range [][]string{{"explain"}, {"explain", "Symbol"}, {"explain", "pkg."}} {
+		if _, err := parseArgs(args); err == nil {
+			t.Errorf("%q: expected error for invalid explain target", args)
+		}
+	}
+
+	opts, err = parseArgs([]string{"lsp", "-backend", "packages"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if !opts.LSP || opts.WorkspaceDir != "" || opts.Backend != engine.BackendPackages {
+		t.Errorf("unexpected lsp options: %+v", opts)
+	}
+	if _, err := parseArgs([]string{"lsp", "-fix"}); err == nil {
+		t.Error("expected error for -fix with lsp")
+	}
+
+	opts, err = parseArgs([]string{"--since", "origin/main"})
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	if opts.Since != "origin/main" {
+		t.Errorf("unexpected since options: %+v", opts)
+	}
+	if _, err := parseArgs([]string{"explain", "-since", "HEAD", "pkg.Symbol"}); err == nil {
+		t.Error("expected error for -since with explain")
+	}
+
+	if _, err := parseArgs([]string{"a/[**"}); err == nil {
+		t.Error("expected error for invalid glob pattern")
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+
+	"github.com/pkg/errors"
+
+	"github.com/rprtr258/punused/engine"
+)
+
+type outputFormat string
+
+const (
+	formatText  outputFormat = "text"
+	formatJSON  outputFormat = "json"
+	formatJSONL outputFormat = "jsonl"
+	formatSARIF outputFormat = "sarif"
+)
+
+var outputFormats = []outputFormat{formatText, formatJSON, formatJSONL, formatSARIF}
+
+// _jsonSchemaVersion must be bumped on any incompatible change of jsonDiagnostic.
+const _jsonSchemaVersion = 1
+
+// reporter writes diagnostics in some output format.
+type reporter interface {
+	Report(engine.Finding) error
+	// Flush is called once after all diagnostics are reported.
+	Flush() error
+}
+
+func newReporter(format outputFormat, w io.Writer, workspaceDir string) (reporter, error) {
+	switch format {
+	case formatText:
+		return &textReporter{w}, nil
+	case formatJSON:
+		return &jsonReporter{w, []jsonDiagnostic{}}, nil
+	case formatJSONL:
+		return &jsonlReporter{json.NewEncoder(w)}, nil
+	case formatSARIF:
+		return &sarifReporter{w, workspaceDir, []sarifResult{}}, nil
+	default:
+		return nil, errors.Errorf("unknown output format %q, must be one of %v", format, outputFormats)
+	}
+}
+
+type textReporter struct {
+	w io.Writer
+}
+
+func (r *textReporter) Report(f engine.Finding) error {
+	_, err := fmt.Fprintln(r.w, f.String())
+	return err
+}
+
+func (r *textReporter) Flush() error {
+	return nil
+}
+
+// jsonLocation is a location in a file, lines and columns are 1-based.
+type jsonLocation struct {
+	Path      string `json:"path"`
+	Line      int    `json:"line"`
+	Column    int    `json:"column"`
+	EndLine   int    `json:"end_line"`
+	EndColumn int    `json:"end_column"`
+}
+
+func newJSONLocation(loc engine.Location) jsonLocation {
+	return jsonLocation{
+		Path:      loc.Path,
+		Line:      loc.Start.Line,
+		Column:    loc.Start.Column,
+		EndLine:   loc.End.Line,
+		EndColumn: loc.End.Column,
+	}
+}
+
+type jsonDiagnostic struct {
+	jsonLocation
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// QualifiedName is name prefixed with package directory and enclosing symbols,
+	// e.g. internal/store.(DB).Path, the same way explain command accepts it.
+	QualifiedName string         `json:"qualified_name"`
+	Detail        string         `json:"detail,omitempty"`
+	Code          string         `json:"code"`
+	Message       string         `json:"message"`
+	Severity      string         `json:"severity"`
+	References    []jsonLocation `json:"references"`
+}
+
+// packageDir returns directory of package finding is in, relative to workspace.
+func packageDir(f engine.Finding) string {
+	return path.Dir(f.Location.Path)
+}
+
+// qualifiedName returns name of symbol prefixed with package directory and enclosing symbols.
+func qualifiedName(f engine.Finding) string {
+	return packageDir(f) + "." + f.QualifiedName
+}
+
+func newJSONDiagnostic(f engine.Finding) jsonDiagnostic {
+	refs := make([]jsonLocation, len(f.References))
+	for i, ref := range f.References {
+		refs[i] = newJSONLocation(ref)
+	}
+
+	return jsonDiagnostic{
+		jsonLocation:  newJSONLocation(f.Location),
+		Kind:          f.Kind,
+		Name:          f.Name,
+		QualifiedName: qualifiedName(f),
+		Detail:        f.Detail,
+		Code:          string(f.Code),
+		Message:       f.Message(),
+		Severity:      string(f.Severity),
+		References:    refs,
+	}
+}
+
+// jsonReporter collects all diagnostics and writes them as single JSON document.
+type jsonReporter struct {
+	w           io.Writer
+	diagnostics []jsonDiagnostic
+}
+
+func (r *jsonReporter) Report(f engine.Finding) error {
+	r.diagnostics = append(r.diagnostics, newJSONDiagnostic(f))
+	return nil
+}
+
+func (r *jsonReporter) Flush() error {
+	enc := json.NewEncoder(r.w)
+	enc.SetIndent("", "  ")
+	return errors.Wrap(enc.Encode(struct {
+		Version     int              `json:"version"`
+		Diagnostics []jsonDiagnostic `json:"diagnostics"`
+	}{_jsonSchemaVersion, r.diagnostics}), "encode diagnostics")
+}
+
+// jsonlReporter writes diagnostics as they come, one JSON object per line.
+type jsonlReporter struct {
+	enc *json.Encoder
+}
+
+func (r *jsonlReporter) Report(f engine.Finding) error {
+	return errors.Wrap(r.enc.Encode(newJSONDiagnostic(f)), "encode diagnostic")
+}
+
+func (r *jsonlReporter) Flush() error {
+	return nil
+}
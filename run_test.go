@@ -2,12 +2,20 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"os"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
 
-	"github.com/gobwas/glob"
 	"github.com/google/go-cmp/cmp"
+	"github.com/xeipuuv/gojsonschema"
+
+	"github.com/rprtr258/punused/engine"
 )
 
 func TestRun(t *testing.T) {
@@ -18,13 +26,8 @@ func TestRun(t *testing.T) {
 		t.FailNow()
 	}
 
-	var buff bytes.Buffer
-	if err := run(t.Context(), glob.MustCompile("testdata/**"), wd, true, &buff); err != nil {
-		t.Fatal(err.Error())
-		t.FailNow()
-	}
-
 	const golden = `
+testdata/entrypoints/main.go:10:6 function TestNotInTestFile is unused (EU1002)
 testdata/firstpackage/code1.go:7:2 variable UnusedVar is unused (EU1002)
 testdata/firstpackage/code1.go:12:2 constant UnusedConst is unused (EU1002)
 testdata/firstpackage/code1.go:19:6 function UnusedFunction is unused (EU1002)
@@ -36,13 +39,349 @@ testdata/firstpackage/code1.go:38:2 method UnusedInterfaceMethodReturningInt is
 testdata/firstpackage/code1.go:41:6 interface UnusedInterface is unused (EU1002)
 testdata/firstpackage/code1.go:42:2 method UnusedInterfaceReturningInt is unused (EU1002)
 testdata/firstpackage/code1.go:45:6 interface UsedInterface is unused (EU1002)
+testdata/firstpackage/suppressed.go:9:6 function IgnoredTestOnlyFunction is unused (EU1002)
+testdata/firstpackage/suppressed.go:16:2 constant UnignoredConst is unused (EU1002)
 testdata/firstpackage/testlib1.go:4:2 constant OnlyUsedInTestConst is used in test only (EU1001)
+testdata/firstpackage/wellknown.go:28:18 method (WellKnown).Stringify is unused (EU1002)
+testdata/firstpackage/suppressed.go:8:1 suppression //punused:ignore EU1001 test helper is stale (EU1003)
+testdata/firstpackage/suppressed.go:24:1 suppression //punused:ignore stale, since function is used is stale (EU1003)
+`
+	for _, backend := range engine.Backends {
+		t.Run(string(backend), func(t *testing.T) {
+			var buff bytes.Buffer
+			if err := run(t.Context(), options{
+				Patterns:     []string{"testdata/**"},
+				WorkspaceDir: wd,
+				SkipTests:    true,
+				Format:       formatText,
+				Backend:      backend,
+			}, &buff); err != nil && !errors.Is(err, errDiagnosticsFound) {
+				t.Fatal(err.Error())
+			}
+			if diff := cmp.Diff(
+				strings.TrimSpace(golden),
+				strings.TrimSpace(buff.String()),
+			); diff != "" {
+				t.Fatal("unexpected output\n+ actual\n- expected\n" + diff)
+			}
+		})
+	}
+}
+
+func TestRunJSONL(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buff bytes.Buffer
+	if err := run(t.Context(), options{
+		Patterns:     []string{"testdata/**"},
+		WorkspaceDir: wd,
+		SkipTests:    true,
+		Format:       formatJSONL,
+	}, &buff); err != nil && !errors.Is(err, errDiagnosticsFound) {
+		t.Fatal(err.Error())
+	}
+
+	var got []string
+	dec := json.NewDecoder(&buff)
+	for dec.More() {
+		var d jsonDiagnostic
+		if err := dec.Decode(&d); err != nil {
+			t.Fatal(err)
+		}
+		if d.Path != "testdata/firstpackage/testlib1.go" && d.Kind != "field" {
+			continue
+		}
+		got = append(got, fmt.Sprintf("%s:%d:%d-%d:%d %s %s %s %s refs=%v",
+			d.Path, d.Line, d.Column, d.EndLine, d.EndColumn, d.Kind, d.Name, d.QualifiedName, d.Code, d.References))
+	}
+
+	want := []string{
+		"testdata/firstpackage/code1.go:25:2-25:13 field UnusedField testdata/firstpackage.(MyType).UnusedField EU1002 refs=[]",
+		"testdata/firstpackage/testlib1.go:4:2-4:21 constant OnlyUsedInTestConst testdata/firstpackage.OnlyUsedInTestConst EU1001 refs=[{testdata/secondpackage/code1_test.go 11 27 11 46}]",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal("unexpected output\n+ actual\n- expected\n" + diff)
+	}
+}
+
+func TestRunSARIF(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buff bytes.Buffer
+	if err := run(t.Context(), options{
+		Patterns:     []string{"testdata/**"},
+		WorkspaceDir: wd,
+		SkipTests:    true,
+		Format:       formatSARIF,
+	}, &buff); err != nil && !errors.Is(err, errDiagnosticsFound) {
+		t.Fatal(err.Error())
+	}
+
+	schema := gojsonschema.NewReferenceLoader("file://" + filepath.ToSlash(filepath.Join(wd, "testdata", "sarif-schema-2.1.0.json")))
+	res, err := gojsonschema.Validate(schema, gojsonschema.NewBytesLoader(buff.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range res.Errors() {
+		t.Error(e.String())
+	}
+
+	var log sarifLog
+	if err := json.Unmarshal(buff.Bytes(), &log); err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, res := range log.Runs[0].Results {
+		loc := res.Locations[0]
+		if path := loc.PhysicalLocation.ArtifactLocation.URI; path != "testdata/firstpackage/code1.go" && path != "testdata/firstpackage/testlib1.go" {
+			continue
+		}
+		got = append(got, fmt.Sprintf("%s:%d:%d %s %s %s",
+			loc.PhysicalLocation.ArtifactLocation.URI,
+			loc.PhysicalLocation.Region.StartLine, loc.PhysicalLocation.Region.StartColumn,
+			res.RuleID,
+			loc.LogicalLocations[0].Kind, loc.LogicalLocations[0].FullyQualifiedName))
+	}
+
+	want := []string{
+		"testdata/firstpackage/code1.go:7:2 EU1002 variable UnusedVar",
+		"testdata/firstpackage/code1.go:12:2 EU1002 variable UnusedConst",
+		"testdata/firstpackage/code1.go:19:6 EU1002 function UnusedFunction",
+		"testdata/firstpackage/code1.go:25:2 EU1002 member (MyType).UnusedField",
+		"testdata/firstpackage/code1.go:32:15 EU1002 member (MyType).UnusedMethod",
+		"testdata/firstpackage/code1.go:36:6 EU1002 type UnusedInterfaceWithUsedAndUnusedMethod",
+		"testdata/firstpackage/code1.go:37:2 EU1002 member (UnusedInterfaceWithUsedAndUnusedMethod).UsedInterfaceMethodReturningInt",
+		"testdata/firstpackage/code1.go:38:2 EU1002 member (UnusedInterfaceWithUsedAndUnusedMethod).UnusedInterfaceMethodReturningInt",
+		"testdata/firstpackage/code1.go:41:6 EU1002 type UnusedInterface",
+		"testdata/firstpackage/code1.go:42:2 EU1002 member (UnusedInterface).UnusedInterfaceReturningInt",
+		"testdata/firstpackage/code1.go:45:6 EU1002 type UsedInterface",
+		"testdata/firstpackage/testlib1.go:4:2 EU1001 variable OnlyUsedInTestConst",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Fatal("unexpected output\n+ actual\n- expected\n" + diff)
+	}
+}
+
+func TestRunBaseline(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opts := options{
+		Patterns:      []string{"testdata/**"},
+		WorkspaceDir:  wd,
+		SkipTests:     true,
+		Format:        formatText,
+		BaselineFile:  filepath.Join(t.TempDir(), "baseline.json"),
+		WriteBaseline: true,
+	}
+	if err := run(t.Context(), opts, io.Discard); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// forget about UnusedVar, so it is reported as new
+	b, err := os.ReadFile(opts.BaselineFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var f baselineFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		t.Fatal(err)
+	}
+	f.Diagnostics = slices.DeleteFunc(f.Diagnostics, func(e baselineEntry) bool {
+		return e.Name == "UnusedVar"
+	})
+	if b, err = json.Marshal(f); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(opts.BaselineFile, b, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var buff bytes.Buffer
+	opts.WriteBaseline = false
+	if err := run(t.Context(), opts, &buff); !errors.Is(err, errDiagnosticsFound) {
+		t.Fatalf("expected diagnostics to be found, got %v", err)
+	}
+
+	const golden = `testdata/firstpackage/code1.go:7:2 variable UnusedVar is unused (EU1002)`
+	if diff := cmp.Diff(golden, strings.TrimSpace(buff.String())); diff != "" {
+		t.Fatal("unexpected output\n+ actual\n- expected\n" + diff)
+	}
+}
+
+func TestRunUnexport(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buff bytes.Buffer
+	if err := run(t.Context(), options{
+		Patterns:     []string{"testdata/**"},
+		WorkspaceDir: wd,
+		SkipTests:    true,
+		Format:       formatText,
+		Unexport:     true,
+	}, &buff); err != nil && !errors.Is(err, errDiagnosticsFound) {
+		t.Fatal(err.Error())
+	}
+
+	var got []string
+	for line := range strings.Lines(buff.String()) {
+		if strings.HasSuffix(line, "(EU1004)\n") {
+			got = append(got, strings.TrimSpace(line))
+		}
+	}
+
+	// methods implementing interfaces from other packages, like (localGreeter).Greet, are not reported
+	const golden = `
+testdata/firstpackage/interfaces.go:18:6 interface Closer is used only in its own package (EU1004)
+testdata/firstpackage/interfaces.go:27:17 method (resource).CloseIt is used only in its own package (EU1004)
+testdata/secondpackage/code1.go:9:6 function UseStuffInFirstPackage is used only in its own package (EU1004)
+testdata/secondpackage/code1.go:21:6 function UseStuffInThisPackage is used only in its own package (EU1004)
+testdata/secondpackage/code1.go:34:6 function GetInterfaceImplementation is used only in its own package (EU1004)
+testdata/secondpackage/code1.go:39:6 function GetInterface2Implementation is used only in its own package (EU1004)
+testdata/secondpackage/code1.go:43:6 class UsedInterfaceInterfaceImpl is used only in its own package (EU1004)
+testdata/secondpackage/code1.go:49:37 method (UsedInterfaceInterfaceImpl).UsedInterfaceMethodReturningInt is used only in its own package (EU1004)
+testdata/secondpackage/code1.go:54:6 class UsedInterfaceInterface2Impl is used only in its own package (EU1004)
+testdata/secondpackage/interfaces.go:5:6 function UseGreeter is used only in its own package (EU1004)
+testdata/secondpackage/interfaces.go:20:6 function UseLocalGreeter is used only in its own package (EU1004)
+`
+	if diff := cmp.Diff(strings.TrimSpace(golden), strings.Join(got, "\n")); diff != "" {
+		t.Fatal("unexpected output\n+ actual\n- expected\n" + diff)
+	}
+}
+
+func TestRunEntrypoints(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buff bytes.Buffer
+	if err := run(t.Context(), options{
+		Patterns:     []string{"testdata/entrypoints/**"},
+		WorkspaceDir: wd,
+		SkipTests:    false,
+		Format:       formatText,
+	}, &buff); err != nil && !errors.Is(err, errDiagnosticsFound) {
+		t.Fatal(err.Error())
+	}
+
+	const golden = `
+testdata/entrypoints/main.go:10:6 function TestNotInTestFile is unused (EU1002)
+testdata/entrypoints/main_test.go:27:6 function Testify is unused (EU1002)
+testdata/entrypoints/main_test.go:30:6 function TestWrongParam is unused (EU1002)
+testdata/entrypoints/main_test.go:33:6 function ExampleWithResult is unused (EU1002)
 `
+	if diff := cmp.Diff(strings.TrimSpace(golden), strings.TrimSpace(buff.String())); diff != "" {
+		t.Fatal("unexpected output\n+ actual\n- expected\n" + diff)
+	}
+}
+
+func TestRunReachability(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buff bytes.Buffer
+	if err := run(t.Context(), options{
+		Patterns:     []string{"testdata/**"},
+		WorkspaceDir: wd,
+		ConfigFile:   filepath.Join("testdata", "reachability.yaml"),
+		SkipTests:    true,
+		Format:       formatText,
+	}, &buff); err != nil && !errors.Is(err, errDiagnosticsFound) {
+		t.Fatal(err.Error())
+	}
+
+	var got []string
+	for line := range strings.Lines(buff.String()) {
+		if strings.HasSuffix(line, "(EU1005)\n") {
+			got = append(got, strings.TrimSpace(line))
+		}
+	}
 
-	if diff := cmp.Diff(
-		strings.TrimSpace(golden),
-		strings.TrimSpace(buff.String()),
-	); diff != "" {
+	// UseStuffInThisPackage, GetInterfaceImplementation and UsedInterfaceMethodReturningInt
+	// call each other, but are not reachable from roots, as well as symbols they use
+	const golden = `
+testdata/firstpackage/code1.go:49:6 interface UsedInterface2 is unreachable (EU1005)
+testdata/secondpackage/code1.go:21:6 function UseStuffInThisPackage is unreachable (EU1005)
+testdata/secondpackage/code1.go:34:6 function GetInterfaceImplementation is unreachable (EU1005)
+testdata/secondpackage/code1.go:39:6 function GetInterface2Implementation is unreachable (EU1005)
+testdata/secondpackage/code1.go:43:6 class UsedInterfaceInterfaceImpl is unreachable (EU1005)
+testdata/secondpackage/code1.go:45:37 method (UsedInterfaceInterfaceImpl).UsedInterfaceReturningInt is unreachable (EU1005)
+testdata/secondpackage/code1.go:49:37 method (UsedInterfaceInterfaceImpl).UsedInterfaceMethodReturningInt is unreachable (EU1005)
+testdata/secondpackage/code1.go:54:6 class UsedInterfaceInterface2Impl is unreachable (EU1005)
+testdata/secondpackage/code1.go:56:38 method (UsedInterfaceInterface2Impl).UsedInterface2ReturningInt is unreachable (EU1005)
+`
+	if diff := cmp.Diff(strings.TrimSpace(golden), strings.Join(got, "\n")); diff != "" {
 		t.Fatal("unexpected output\n+ actual\n- expected\n" + diff)
 	}
 }
+
+func TestRunExplain(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	explain := func(target string) string {
+		t.Helper()
+		var buff bytes.Buffer
+		if err := run(t.Context(), options{
+			Patterns:     []string{"testdata/**"},
+			WorkspaceDir: wd,
+			ConfigFile:   filepath.Join("testdata", "reachability.yaml"),
+			Format:       formatText,
+			Explain:      target,
+		}, &buff); err != nil {
+			t.Fatal(err.Error())
+		}
+		return buff.String()
+	}
+
+	for target, golden := range map[string]string{
+		"firstpackage.(MyType).UsedMethod": `
+testdata/firstpackage/code1.go:28:15 method (MyType).UsedMethod
+diagnostics:
+	none
+references (1):
+	testdata/secondpackage/code1.go:17:5 in function UseStuffInFirstPackage
+path from root:
+	testdata/secondpackage/code1.go:9:6 function UseStuffInFirstPackage (root: configured root)
+	testdata/firstpackage/code1.go:28:15 method (MyType).UsedMethod
+`,
+		"testdata/firstpackage.OnlyUsedInTestConst": `
+testdata/firstpackage/testlib1.go:4:2 constant OnlyUsedInTestConst
+diagnostics:
+	used in test only (EU1001), error
+references (1):
+	testdata/secondpackage/code1_test.go:11:27 in function TestUseTestlib1 (test)
+path from root:
+	testdata/secondpackage/code1_test.go:10:6 function TestUseTestlib1 (root: called by Go toolchain)
+	testdata/firstpackage/testlib1.go:4:2 constant OnlyUsedInTestConst
+`,
+		"secondpackage.GetInterfaceImplementation": `
+testdata/secondpackage/code1.go:34:6 function GetInterfaceImplementation
+diagnostics:
+	unreachable (EU1005), error
+references (1):
+	testdata/secondpackage/code1.go:50:2 in method (UsedInterfaceInterfaceImpl).UsedInterfaceMethodReturningInt
+not reachable from any root
+`,
+	} {
+		if diff := cmp.Diff(strings.TrimSpace(golden), strings.TrimSpace(explain(target))); diff != "" {
+			t.Error("unexpected output\n+ actual\n- expected\n" + diff)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"path/filepath"
+	"slices"
+
+	"github.com/pkg/errors"
+
+	"github.com/rprtr258/punused/engine"
+)
+
+// SARIF 2.1.0 report, see https://docs.oasis-open.org/sarif/sarif/v2.1.0/sarif-v2.1.0.html
+// Only properties used by punused are declared.
+
+const (
+	_sarifVersion = "2.1.0"
+	_sarifSchema  = "https://json.schemastore.org/sarif-2.1.0.json"
+	// _sarifSrcRoot is base id against which result paths are resolved.
+	_sarifSrcRoot = "%SRCROOT%"
+)
+
+type sarifMessage struct {
+	Text string `json:"text"`
+}
+
+type sarifRule struct {
+	ID                   string       `json:"id"`
+	Name                 string       `json:"name"`
+	ShortDescription     sarifMessage `json:"shortDescription"`
+	FullDescription      sarifMessage `json:"fullDescription"`
+	HelpURI              string       `json:"helpUri"`
+	DefaultConfiguration struct {
+		Level string `json:"level"`
+	} `json:"defaultConfiguration"`
+}
+
+func newSARIFRule(c engine.Code, level, name, short, full string) sarifRule {
+	r := sarifRule{
+		ID:               string(c),
+		Name:             name,
+		ShortDescription: sarifMessage{short},
+		FullDescription:  sarifMessage{full},
+		HelpURI:          "https://github.com/rprtr258/punused#readme",
+	}
+	r.DefaultConfiguration.Level = level
+	return r
+}
+
+var _sarifRules = []sarifRule{
+	newSARIFRule(engine.CodeTestOnly, "warning", "UsedInTestOnly",
+		"Exported symbol is used in tests only",
+		"Exported symbol is referenced only from _test.go files, so it is dead code outside of tests."),
+	newSARIFRule(engine.CodeUnused, "warning", "Unused",
+		"Exported symbol is unused",
+		"Exported symbol is not referenced anywhere in the workspace."),
+	newSARIFRule(engine.CodeStaleSuppression, "warning", "StaleSuppression",
+		"Suppression does not suppress anything",
+		"Suppression directive or exclude.symbols config entry does not match any diagnostic, so it can be removed."),
+	newSARIFRule(engine.CodeUnexportable, "note", "UsedInOwnPackageOnly",
+		"Exported symbol is used only in its own package",
+		"Exported symbol is referenced only from the package it is declared in, so it can be unexported."),
+	newSARIFRule(engine.CodeUnreachable, "warning", "Unreachable",
+		"Symbol is unreachable",
+		"Symbol is referenced only from symbols which are themselves unused or unreachable from entrypoints and exported API, e.g. functions calling only each other."),
+}
+
+type sarifArtifactLocation struct {
+	URI       string `json:"uri"`
+	URIBaseID string `json:"uriBaseId,omitempty"`
+}
+
+type sarifRegion struct {
+	StartLine   int `json:"startLine"`
+	StartColumn int `json:"startColumn"`
+	EndLine     int `json:"endLine"`
+	EndColumn   int `json:"endColumn"`
+}
+
+type sarifPhysicalLocation struct {
+	ArtifactLocation sarifArtifactLocation `json:"artifactLocation"`
+	Region           sarifRegion           `json:"region"`
+}
+
+type sarifLogicalLocation struct {
+	Name               string `json:"name"`
+	FullyQualifiedName string `json:"fullyQualifiedName"`
+	Kind               string `json:"kind"`
+}
+
+type sarifLocation struct {
+	PhysicalLocation sarifPhysicalLocation  `json:"physicalLocation"`
+	LogicalLocations []sarifLogicalLocation `json:"logicalLocations,omitempty"`
+}
+
+type sarifResult struct {
+	RuleID           string          `json:"ruleId"`
+	RuleIndex        int             `json:"ruleIndex"`
+	Level            string          `json:"level"`
+	Message          sarifMessage    `json:"message"`
+	Locations        []sarifLocation `json:"locations"`
+	RelatedLocations []sarifLocation `json:"relatedLocations,omitempty"`
+	// PartialFingerprints are used by viewers to track results across runs.
+	PartialFingerprints map[string]string `json:"partialFingerprints,omitempty"`
+}
+
+type sarifRun struct {
+	Tool struct {
+		Driver struct {
+			Name           string      `json:"name"`
+			InformationURI string      `json:"informationUri"`
+			Rules          []sarifRule `json:"rules"`
+		} `json:"driver"`
+	} `json:"tool"`
+	OriginalURIBaseIDs map[string]sarifArtifactLocation `json:"originalUriBaseIds"`
+	Results            []sarifResult                    `json:"results"`
+}
+
+type sarifLog struct {
+	Schema  string     `json:"$schema"`
+	Version string     `json:"version"`
+	Runs    []sarifRun `json:"runs"`
+}
+
+// sarifLogicalKind maps symbol kind to one of SARIF logical location kinds.
+func sarifLogicalKind(kind string) string {
+	switch kind {
+	case "function":
+		return "function"
+	case "method", "field":
+		return "member"
+	case "interface", "struct", "class":
+		return "type"
+	default:
+		return "variable"
+	}
+}
+
+// sarifReporter collects all diagnostics and writes them as single SARIF log.
+type sarifReporter struct {
+	w            io.Writer
+	workspaceDir string
+	results      []sarifResult
+}
+
+func newSARIFPhysicalLocation(loc engine.Location) sarifPhysicalLocation {
+	return sarifPhysicalLocation{
+		ArtifactLocation: sarifArtifactLocation{
+			URI:       loc.Path,
+			URIBaseID: _sarifSrcRoot,
+		},
+		Region: sarifRegion{
+			StartLine:   loc.Start.Line,
+			StartColumn: loc.Start.Column,
+			EndLine:     loc.End.Line,
+			EndColumn:   loc.End.Column,
+		},
+	}
+}
+
+func (r *sarifReporter) Report(f engine.Finding) error {
+	ruleIndex := slices.IndexFunc(_sarifRules, func(rule sarifRule) bool { return rule.ID == string(f.Code) })
+	if ruleIndex == -1 {
+		return errors.Errorf("no SARIF rule for %s", f.Code)
+	}
+
+	loc := newSARIFPhysicalLocation(f.Location)
+	related := make([]sarifLocation, len(f.References))
+	for i, ref := range f.References {
+		related[i].PhysicalLocation = newSARIFPhysicalLocation(ref)
+	}
+
+	var logical []sarifLogicalLocation
+	if f.Code != engine.CodeStaleSuppression {
+		logical = []sarifLogicalLocation{{
+			Name:               f.Name,
+			FullyQualifiedName: f.QualifiedName,
+			Kind:               sarifLogicalKind(f.Kind),
+		}}
+	}
+
+	r.results = append(r.results, sarifResult{
+		RuleID:    string(f.Code),
+		RuleIndex: ruleIndex,
+		Level:     string(f.Severity),
+		Message:   sarifMessage{f.Kind + " " + f.QualifiedName + " is " + f.Message()},
+		Locations: []sarifLocation{{
+			PhysicalLocation: loc,
+			LogicalLocations: logical,
+		}},
+		RelatedLocations:    related,
+		PartialFingerprints: map[string]string{"punused/v1": newBaselineEntry(f).fingerprint()},
+	})
+	return nil
+}
+
+func (r *sarifReporter) Flush() error {
+	run := sarifRun{
+		OriginalURIBaseIDs: map[string]sarifArtifactLocation{
+			_sarifSrcRoot: {URI: "file://" + filepath.ToSlash(r.workspaceDir) + "/"},
+		},
+		Results: r.results,
+	}
+	run.Tool.Driver.Name = "punused"
+	run.Tool.Driver.InformationURI = "https://github.com/rprtr258/punused"
+	run.Tool.Driver.Rules = _sarifRules
+
+	enc := json.NewEncoder(r.w)
+	enc.SetIndent("", "  ")
+	return errors.Wrap(enc.Encode(sarifLog{
+		Schema:  _sarifSchema,
+		Version: _sarifVersion,
+		Runs:    []sarifRun{run},
+	}), "encode SARIF log")
+}
@@ -0,0 +1,7 @@
+package main
+
+import . "testing"
+
+func TestDotImport(t *T) {}
+
+func BenchmarkDotImport(b *B) {}
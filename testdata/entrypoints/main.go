@@ -0,0 +1,10 @@
+package main
+
+func init() {}
+
+func init() {}
+
+func main() {}
+
+// TestNotInTestFile is not a test, since it is declared outside of _test.go file.
+func TestNotInTestFile() {}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"os"
+	tst "testing"
+)
+
+func TestMain(m *tst.M) {
+	os.Exit(m.Run())
+}
+
+func TestAliased(test *tst.T) {}
+
+func Test(*tst.T) {}
+
+func Test_underscore(t *tst.T) {}
+
+func BenchmarkAliased(b *tst.B) {}
+
+func FuzzAliased(f *tst.F) {}
+
+func Example() {}
+
+func ExampleAliased_suffix() {}
+
+// Testify is not a test, since lower case letter follows Test.
+func Testify(t *tst.T) {}
+
+// TestWrongParam is not a test, since it takes *testing.B.
+func TestWrongParam(b *tst.B) {}
+
+// ExampleWithResult is not an example, since examples have no results.
+func ExampleWithResult() int {
+	return 0
+}
@@ -0,0 +1,33 @@
+package firstpackage
+
+type Greeter interface {
+	Greet() string
+}
+
+type englishGreeter struct{}
+
+// Greet is called only through Greeter interface.
+func (englishGreeter) Greet() string {
+	return "hello"
+}
+
+func NewGreeter() Greeter {
+	return englishGreeter{}
+}
+
+type Closer interface {
+	CloseIt() error
+}
+
+var _ Closer = resource{}
+
+type resource struct{}
+
+// CloseIt is called only directly, but implements Closer.CloseIt.
+func (resource) CloseIt() error {
+	return nil
+}
+
+func UseResource() {
+	_ = resource{}.CloseIt()
+}
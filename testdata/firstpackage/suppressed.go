@@ -0,0 +1,25 @@
+package firstpackage
+
+//punused:ignore kept for backward compatibility
+func IgnoredFunction() {}
+
+// IgnoredTestOnlyFunction is ignored only if it is used in tests.
+//
+//punused:ignore EU1001 test helper
+func IgnoredTestOnlyFunction() {}
+
+//nolint:punused // golangci-lint style
+var NolintVar = "NolintVar"
+
+const (
+	IgnoredConst   = "IgnoredConst" //punused:ignore
+	UnignoredConst = "UnignoredConst"
+)
+
+//punused:ignore EU1002
+type IgnoredStruct struct {
+	IgnoredField string
+}
+
+//punused:ignore stale, since function is used
+func UsedIgnoredFunction() {}
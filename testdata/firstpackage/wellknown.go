@@ -0,0 +1,30 @@
+package firstpackage
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// WellKnown implements well-known interfaces, so its methods are not reported.
+type WellKnown struct{}
+
+func (WellKnown) String() string {
+	return "WellKnown"
+}
+
+func (w WellKnown) Format(f fmt.State, verb rune) {}
+
+func (*WellKnown) Read(p []byte) (n int, err error) {
+	return 0, nil
+}
+
+func (WellKnown) ServeHTTP(w http.ResponseWriter, r *http.Request) {}
+
+func (WellKnown) Scan(src any) error {
+	return nil
+}
+
+// Stringify has signature of String, but different name.
+func (WellKnown) Stringify() string {
+	return "WellKnown"
+}
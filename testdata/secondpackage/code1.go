@@ -8,6 +8,7 @@ import (
 
 func UseStuffInFirstPackage() {
 	firstpackage.UsedFunction()
+	firstpackage.UsedIgnoredFunction()
 	fmt.Println(firstpackage.UsedVar, firstpackage.UsedConst)
 
 	mt := firstpackage.MyType{
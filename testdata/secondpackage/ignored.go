@@ -0,0 +1,9 @@
+//punused:file-ignore whole file is ignored
+
+package secondpackage
+
+func IgnoredByFileFunction() {}
+
+type IgnoredByFileStruct struct {
+	IgnoredByFileField int
+}
@@ -0,0 +1,23 @@
+package secondpackage
+
+import "github.com/rprtr258/punused-testdata/firstpackage"
+
+func UseGreeter() string {
+	firstpackage.UseResource()
+	UseLocalGreeter()
+	return firstpackage.NewGreeter().Greet()
+}
+
+var _ = firstpackage.WellKnown{}
+
+type localGreeter struct{}
+
+// Greet implements interface from other package, so it can't be unexported.
+func (localGreeter) Greet() string {
+	return "hi"
+}
+
+func UseLocalGreeter() firstpackage.Greeter {
+	UseGreeter()
+	return localGreeter{}
+}